@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"photo-sync-server/export"
+	"photo-sync-server/storage"
+)
+
+// runMigrateLayout переносит уже сохраненные фото под шаблон раскладки и обновляет индекс
+func runMigrateLayout(args []string) {
+	flags := flag.NewFlagSet("migrate-layout", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only show what would be moved")
+	cfg := loadConfig(flags, args)
+
+	layout, err := storage.ParseLayout(cfg.Layout)
+	if err != nil {
+		logErrorAndExit("Invalid layout template: %v", err)
+	}
+
+	baseDir, indexDir := resolveDirectories(cfg)
+	fileManager := storage.NewFileManager(baseDir, layout)
+	indexer, err := storage.NewIndexer(indexDir)
+	if err != nil {
+		logErrorAndExit("Failed to open photo index: %v", err)
+	}
+	defer indexer.Close()
+
+	log.Printf("Migrating photos in %s to layout %s", baseDir, layout)
+	report, err := storage.MigrateLayout(indexer, fileManager, *dryRun, log.Printf)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		os.Exit(1)
+	}
+
+	if *dryRun {
+		log.Printf("Dry run: %d files would be moved, %d already in place, %d missing", report.Moved, report.Unchanged, report.MissingFiles)
+		return
+	}
+	log.Printf("Migration finished: %d moved, %d already in place, %d missing, %d failed", report.Moved, report.Unchanged, report.MissingFiles, report.Failed)
+	if report.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// runRegenThumbnails заново создает миниатюры для всех фото из индекса
+func runRegenThumbnails(args []string) {
+	flags := flag.NewFlagSet("regen-thumbnails", flag.ContinueOnError)
+	clearCache := flags.Bool("clear", false, "remove the whole cache before regenerating (drops thumbnails of deleted photos)")
+	cfg := loadConfig(flags, args)
+
+	baseDir, indexDir := resolveDirectories(cfg)
+	indexer, err := storage.NewIndexer(indexDir)
+	if err != nil {
+		logErrorAndExit("Failed to open photo index: %v", err)
+	}
+	defer indexer.Close()
+	thumbnails, err := storage.NewThumbnailCache(filepath.Join(indexDir, "thumbs"))
+	if err != nil {
+		logErrorAndExit("Failed to initialize thumbnail cache: %v", err)
+	}
+
+	if *clearCache {
+		if err := thumbnails.Clear(); err != nil {
+			logErrorAndExit("Failed to clear thumbnail cache: %v", err)
+		}
+		log.Printf("Thumbnail cache cleared: %s", thumbnails.Dir())
+	}
+
+	type entry struct {
+		hash string
+		path string
+	}
+	var entries []entry
+	indexer.ForEachPhoto(func(counterNumber string, photo *storage.PhotoInfo) {
+		entries = append(entries, entry{hash: photo.Hash, path: photo.Path})
+	})
+
+	var generated, skipped, failed int
+	for i, e := range entries {
+		if e.hash == "" {
+			skipped++
+			continue
+		}
+		err := thumbnails.Generate(e.hash, filepath.Join(baseDir, e.path))
+		switch {
+		case err == nil:
+			generated++
+		case errors.Is(err, storage.ErrUnsupportedImage), errors.Is(err, os.ErrNotExist):
+			skipped++
+			log.Printf("Skipped %s: %v", e.path, err)
+		default:
+			failed++
+			log.Printf("Failed %s: %v", e.path, err)
+		}
+		if (i+1)%100 == 0 {
+			log.Printf("Processed %d of %d photos", i+1, len(entries))
+		}
+	}
+
+	log.Printf("Thumbnails regenerated: %d photos, %d skipped, %d failed", generated, skipped, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// runExport выгружает фото и показания из индекса в CSV или XLSX (формат по расширению файла)
+func runExport(args []string) {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	output := flags.String("o", "photos.csv", "output file: .csv or .xlsx (- writes CSV to stdout)")
+	counter := flags.String("counter", "", "export only this counter")
+	from := flags.String("from", "", "first day of the period, YYYY-MM-DD")
+	to := flags.String("to", "", "last day of the period, YYYY-MM-DD")
+	cfg := loadConfig(flags, args)
+
+	filter, err := export.ParseFilter(*counter, *from, *to)
+	if err != nil {
+		logErrorAndExit("Invalid export filter: %v", err)
+	}
+
+	var write func(io.Writer, *export.Table) error
+	switch strings.ToLower(filepath.Ext(*output)) {
+	case ".csv":
+		write = export.WriteCSV
+	case ".xlsx":
+		write = export.WriteXLSX
+	default:
+		if *output != "-" {
+			logErrorAndExit("Unsupported export format %s: use .csv or .xlsx", *output)
+		}
+		write = export.WriteCSV
+	}
+
+	_, indexDir := resolveDirectories(cfg)
+	indexer, err := storage.NewIndexer(indexDir)
+	if err != nil {
+		logErrorAndExit("Failed to open photo index: %v", err)
+	}
+	defer indexer.Close()
+
+	table := export.Select(indexer, filter)
+	if *output == "-" {
+		if err := write(os.Stdout, table); err != nil {
+			logErrorAndExit("Failed to write export: %v", err)
+		}
+		return
+	}
+
+	file, err := os.Create(*output)
+	if err != nil {
+		logErrorAndExit("Failed to create %s: %v", *output, err)
+	}
+	if err := write(file, table); err != nil {
+		file.Close()
+		logErrorAndExit("Failed to write %s: %v", *output, err)
+	}
+	if err := file.Close(); err != nil {
+		logErrorAndExit("Failed to write %s: %v", *output, err)
+	}
+	log.Printf("Exported %d photos to %s", len(table.Rows), *output)
+}
@@ -0,0 +1,462 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"photo-sync-server/storage"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix - префикс переменных окружения с настройками
+const EnvPrefix = "PHOTOSYNC_"
+
+// DefaultConfigFile - имя файла настроек, который ищется рядом с exe
+const DefaultConfigFile = "photo-sync.yaml"
+
+// Config содержит настройки сервера
+type Config struct {
+	Listen         string        // адрес для входящих соединений, например :8080
+	PhotoDir       string        // папка для фото (пусто - выбирается автоматически)
+	IndexDir       string        // папка для индексов (пусто - {PhotoDir}/.index)
+	SessionTTL     time.Duration // время жизни неактивной сессии
+	UploadTTL      time.Duration // время жизни брошенной возобновляемой загрузки
+	MaxUploadSize  int64         // максимальный размер одного фото в байтах
+	Layout         string        // шаблон раскладки фото по папкам
+	AdminKey       string        // ключ администратора (пусто - генерируется в папке индекса)
+	AdminLocalhost bool          // разрешать управление с этого ПК (localhost) без ключа
+	TLS            bool          // обслуживать HTTPS
+	TLSCert        string        // свой сертификат (пусто - самоподписанный в папке индекса)
+	TLSKey         string        // ключ своего сертификата
+	MDNS           bool          // объявлять сервер в локальной сети по mDNS
+
+	// ConfigFile - путь к прочитанному файлу настроек (пусто, если файла нет)
+	ConfigFile string
+
+	sources map[string]string
+}
+
+// setting описывает одну настройку и все способы ее задать
+type setting struct {
+	key    string // ключ в файле настроек
+	usage  string
+	secret bool // значение не выводится в лог
+	get    func(c *Config) string
+	set    func(c *Config, value string) error
+}
+
+// settings - список всех настроек в порядке вывода
+var settings = []setting{
+	{
+		key:   "listen",
+		usage: "address to listen on, e.g. :8080 or 192.168.1.10:8080",
+		get:   func(c *Config) string { return c.Listen },
+		set: func(c *Config, value string) error {
+			if _, err := parsePort(value); err != nil {
+				return err
+			}
+			c.Listen = value
+			return nil
+		},
+	},
+	{
+		key:   "photo_dir",
+		usage: "directory for photos (default: 'meter' next to the executable with fallbacks)",
+		get:   func(c *Config) string { return c.PhotoDir },
+		set:   func(c *Config, value string) error { c.PhotoDir = value; return nil },
+	},
+	{
+		key:   "index_dir",
+		usage: "directory for the photo index (default: {photo_dir}/.index)",
+		get:   func(c *Config) string { return c.IndexDir },
+		set:   func(c *Config, value string) error { c.IndexDir = value; return nil },
+	},
+	{
+		key:   "session_ttl",
+		usage: "inactive sync session lifetime, e.g. 1h or 30m",
+		get:   func(c *Config) string { return c.SessionTTL.String() },
+		set: func(c *Config, value string) error {
+			d, err := parseDuration(value, time.Minute)
+			if err != nil {
+				return err
+			}
+			c.SessionTTL = d
+			return nil
+		},
+	},
+	{
+		key:   "upload_ttl",
+		usage: "lifetime of an abandoned resumable upload, e.g. 24h",
+		get:   func(c *Config) string { return c.UploadTTL.String() },
+		set: func(c *Config, value string) error {
+			d, err := parseDuration(value, time.Minute)
+			if err != nil {
+				return err
+			}
+			c.UploadTTL = d
+			return nil
+		},
+	},
+	{
+		key:   "max_upload_size",
+		usage: "maximum size of a single photo, e.g. 64MB",
+		get:   func(c *Config) string { return formatSize(c.MaxUploadSize) },
+		set: func(c *Config, value string) error {
+			size, err := parseSize(value)
+			if err != nil {
+				return err
+			}
+			c.MaxUploadSize = size
+			return nil
+		},
+	},
+	{
+		key:   "layout",
+		usage: "layout template for photo paths",
+		get:   func(c *Config) string { return c.Layout },
+		set: func(c *Config, value string) error {
+			if _, err := storage.ParseLayout(value); err != nil {
+				return err
+			}
+			c.Layout = value
+			return nil
+		},
+	},
+	{
+		key:    "admin_key",
+		usage:  "admin API key for management endpoints (default: generated into {index_dir}/admin.key)",
+		secret: true,
+		get:    func(c *Config) string { return c.AdminKey },
+		set: func(c *Config, value string) error {
+			if value != "" && len(value) < 16 {
+				return fmt.Errorf("must be at least 16 characters")
+			}
+			c.AdminKey = value
+			return nil
+		},
+	},
+	{
+		key:   "admin_localhost",
+		usage: "allow management endpoints from this PC (localhost) without the admin key",
+		get:   func(c *Config) string { return strconv.FormatBool(c.AdminLocalhost) },
+		set: func(c *Config, value string) error {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("expected true or false")
+			}
+			c.AdminLocalhost = b
+			return nil
+		},
+	},
+	{
+		key:   "tls",
+		usage: "serve HTTPS (self-signed certificate is generated on first run)",
+		get:   func(c *Config) string { return strconv.FormatBool(c.TLS) },
+		set: func(c *Config, value string) error {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("expected true or false")
+			}
+			c.TLS = b
+			return nil
+		},
+	},
+	{
+		key:   "tls_cert",
+		usage: "path to your own PEM certificate (requires tls_key)",
+		get:   func(c *Config) string { return c.TLSCert },
+		set:   func(c *Config, value string) error { c.TLSCert = value; return nil },
+	},
+	{
+		key:   "tls_key",
+		usage: "path to the PEM private key for tls_cert",
+		get:   func(c *Config) string { return c.TLSKey },
+		set:   func(c *Config, value string) error { c.TLSKey = value; return nil },
+	},
+	{
+		key:   "mdns",
+		usage: "advertise the server on the local network as _photosync._tcp",
+		get:   func(c *Config) string { return strconv.FormatBool(c.MDNS) },
+		set: func(c *Config, value string) error {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("expected true or false")
+			}
+			c.MDNS = b
+			return nil
+		},
+	},
+}
+
+// Default возвращает настройки по умолчанию
+func Default() *Config {
+	return &Config{
+		Listen:         ":8080",
+		SessionTTL:     1 * time.Hour,
+		UploadTTL:      24 * time.Hour,
+		MaxUploadSize:  64 << 20,
+		Layout:         storage.DefaultLayout,
+		AdminLocalhost: true,
+		MDNS:           true,
+		sources:        make(map[string]string),
+	}
+}
+
+// Load собирает настройки из значений по умолчанию, файла настроек, переменных окружения
+// и флагов командной строки (в порядке возрастания приоритета).
+// Флаги регистрируются в fs, поэтому подкоманды могут добавить к ним свои.
+func Load(fs *flag.FlagSet, args []string) (*Config, error) {
+	cfg := Default()
+
+	configFile := fs.String("config", "", "path to YAML config file (env "+EnvPrefix+"CONFIG)")
+	flagValues := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		flagValues[s.key] = fs.String(flagName(s.key), "", s.usage+" (env "+envName(s.key)+")")
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// Файл настроек: флаг, переменная окружения или photo-sync.yaml рядом с exe
+	path, required := *configFile, true
+	if path == "" {
+		path = os.Getenv(EnvPrefix + "CONFIG")
+	}
+	if path == "" {
+		path, required = defaultConfigPath(), false
+	}
+	if path != "" {
+		if err := cfg.loadFile(path, required); err != nil {
+			return nil, err
+		}
+	}
+
+	// Переменные окружения
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(envName(s.key)); ok {
+			if err := cfg.apply(s, value, "env "+envName(s.key)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Флаги, заданные явно
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if flagErr == nil && f.Name == flagName(s.key) {
+				flagErr = cfg.apply(s, *flagValues[s.key], "flag -"+f.Name)
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// validate проверяет согласованность настроек между собой
+func (c *Config) validate() error {
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return fmt.Errorf("tls_cert and tls_key must be set together")
+	}
+	if c.TLSCert != "" && !c.TLS {
+		return fmt.Errorf("tls_cert is set but tls is disabled")
+	}
+	return nil
+}
+
+// Scheme возвращает схему URL сервера: https или http
+func (c *Config) Scheme() string {
+	if c.TLS {
+		return "https"
+	}
+	return "http"
+}
+
+// Port возвращает номер порта из адреса Listen
+func (c *Config) Port() int {
+	port, _ := parsePort(c.Listen)
+	return port
+}
+
+// ListenHost возвращает IP адрес из listen, если сервер слушает только его
+// (пусто, если слушаются все интерфейсы)
+func (c *Config) ListenHost() string {
+	host, _, err := net.SplitHostPort(c.Listen)
+	if err != nil {
+		return ""
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		return ""
+	}
+	return host
+}
+
+// Print выводит действующие настройки и их источники
+func (c *Config) Print(logf func(format string, args ...interface{})) {
+	if c.ConfigFile != "" {
+		logf("Config file: %s", c.ConfigFile)
+	}
+	logf("Effective configuration:")
+	for _, s := range settings {
+		value := s.get(c)
+		if value == "" {
+			value = "(auto)"
+		} else if s.secret {
+			value = "(set)"
+		}
+		source := c.sources[s.key]
+		if source == "" {
+			source = "default"
+		}
+		logf("  %-16s = %s [%s]", s.key, value, source)
+	}
+}
+
+// loadFile читает настройки из YAML файла
+func (c *Config) loadFile(path string, required bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && !required {
+			return nil
+		}
+		return fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	// Неизвестные ключи - скорее всего опечатка, о ней лучше сказать сразу
+	known := make(map[string]setting, len(settings))
+	for _, s := range settings {
+		known[s.key] = s
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s, ok := known[key]
+		if !ok {
+			return fmt.Errorf("config file %s: unknown setting %q", path, key)
+		}
+		if err := c.apply(s, fmt.Sprint(values[key]), "file"); err != nil {
+			return err
+		}
+	}
+
+	c.ConfigFile = path
+	return nil
+}
+
+// apply устанавливает значение настройки и запоминает его источник
+func (c *Config) apply(s setting, value string, source string) error {
+	if err := s.set(c, strings.TrimSpace(value)); err != nil {
+		return fmt.Errorf("invalid value %q for %s (from %s): %w", value, s.key, source, err)
+	}
+	c.sources[s.key] = source
+	return nil
+}
+
+// defaultConfigPath возвращает путь к photo-sync.yaml рядом с exe
+func defaultConfigPath() string {
+	exePath, err := os.Executable()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(exePath), DefaultConfigFile)
+}
+
+// flagName превращает ключ настройки в имя флага: session_ttl -> session-ttl
+func flagName(key string) string {
+	return strings.ReplaceAll(key, "_", "-")
+}
+
+// envName превращает ключ настройки в имя переменной окружения: session_ttl -> PHOTOSYNC_SESSION_TTL
+func envName(key string) string {
+	return EnvPrefix + strings.ToUpper(key)
+}
+
+// parsePort проверяет адрес вида host:port и возвращает порт
+func parsePort(listen string) (int, error) {
+	_, portStr, err := net.SplitHostPort(listen)
+	if err != nil {
+		return 0, fmt.Errorf("expected host:port or :port")
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("port must be a number between 1 and 65535")
+	}
+	return port, nil
+}
+
+// parseDuration разбирает длительность вида 1h30m и проверяет минимальное значение
+func parseDuration(value string, min time.Duration) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("expected duration like 30m or 24h")
+	}
+	if d < min {
+		return 0, fmt.Errorf("must be at least %s", min)
+	}
+	return d, nil
+}
+
+// sizeUnits - множители для размеров
+var sizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// parseSize разбирает размер вида 64MB, 512KB или число байт
+func parseSize(value string) (int64, error) {
+	upper := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(upper, unit.suffix) {
+			upper = strings.TrimSpace(strings.TrimSuffix(upper, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(upper, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("expected positive size like 64MB, 512KB or bytes")
+	}
+	return n * multiplier, nil
+}
+
+// formatSize форматирует размер в наиболее крупных целых единицах
+func formatSize(size int64) string {
+	for _, unit := range sizeUnits {
+		if size >= unit.multiplier && size%unit.multiplier == 0 {
+			return fmt.Sprintf("%d%s", size/unit.multiplier, unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", size)
+}
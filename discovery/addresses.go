@@ -0,0 +1,67 @@
+package discovery
+
+import (
+	"net"
+	"sort"
+)
+
+// LocalAddresses возвращает адреса всех активных сетевых интерфейсов, кроме loopback.
+// Link-local адреса пропускаются: по ним телефон в локальной сети сервер не найдет.
+// Частные IPv4 адреса (192.168.x.x, 10.x.x.x, 172.16-31.x.x) идут первыми,
+// потому что обычно именно через них телефон и ПК видят друг друга.
+func LocalAddresses() ([]string, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []net.IP
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			ip := ipNet.IP
+			if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsMulticast() || ip.IsUnspecified() {
+				continue
+			}
+			ips = append(ips, ip)
+		}
+	}
+
+	sort.SliceStable(ips, func(i, j int) bool {
+		return addressRank(ips[i]) < addressRank(ips[j])
+	})
+
+	addresses := make([]string, 0, len(ips))
+	seen := make(map[string]bool)
+	for _, ip := range ips {
+		address := ip.String()
+		if !seen[address] {
+			seen[address] = true
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses, nil
+}
+
+// addressRank задает порядок адресов: частные IPv4, остальные IPv4, затем IPv6
+func addressRank(ip net.IP) int {
+	switch {
+	case ip.To4() != nil && ip.IsPrivate():
+		return 0
+	case ip.To4() != nil:
+		return 1
+	default:
+		return 2
+	}
+}
@@ -0,0 +1,63 @@
+package discovery
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/grandcat/zeroconf"
+)
+
+// ServiceType - тип сервиса DNS-SD, по которому приложение ищет сервер в локальной сети
+const ServiceType = "_photosync._tcp"
+
+// Service описывает объявляемый в сети сервер
+type Service struct {
+	Version   string   // версия сервера
+	Port      int      // порт API
+	TLS       bool     // сервер работает по HTTPS
+	Addresses []string // адреса, которые объявляются в A/AAAA записях
+}
+
+// Advertiser объявляет сервер по mDNS, пока не будет вызван Shutdown
+type Advertiser struct {
+	server *zeroconf.Server
+}
+
+// Advertise начинает объявлять сервер как _photosync._tcp в домене local.
+// В TXT записях передаются version, port и tls.
+func Advertise(service Service) (*Advertiser, error) {
+	if len(service.Addresses) == 0 {
+		return nil, fmt.Errorf("no addresses to advertise")
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "photo-sync-server"
+	}
+	// В имени хоста mDNS допустимы только буквы, цифры и дефис
+	host := strings.Map(func(r rune) rune {
+		if r == '-' || (r >= '0' && r <= '9') || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
+			return r
+		}
+		return '-'
+	}, strings.SplitN(hostname, ".", 2)[0])
+
+	text := []string{
+		"version=" + service.Version,
+		"port=" + strconv.Itoa(service.Port),
+		"tls=" + strconv.FormatBool(service.TLS),
+	}
+
+	server, err := zeroconf.RegisterProxy("Photo Sync "+hostname, ServiceType, "local.", service.Port, host, service.Addresses, text, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Advertiser{server: server}, nil
+}
+
+// Shutdown прекращает объявление сервера и отправляет в сеть его удаление
+func (a *Advertiser) Shutdown() {
+	a.server.Shutdown()
+}
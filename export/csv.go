@@ -0,0 +1,71 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeLayout - формат даты съемки в CSV (местное время ПК)
+const TimeLayout = "2006-01-02 15:04:05"
+
+// WriteCSV записывает выгрузку в CSV (разделитель ",", UTF-8 с BOM, чтобы Excel
+// правильно показал русский текст). Числа записываются с точкой.
+func WriteCSV(w io.Writer, table *Table) error {
+	if _, err := io.WriteString(w, "\xEF\xBB\xBF"); err != nil {
+		return err
+	}
+
+	writer := csv.NewWriter(w)
+	columns := table.Columns()
+	record := make([]string, len(columns))
+	for i, column := range columns {
+		record[i] = column.Name
+	}
+	if err := writer.Write(record); err != nil {
+		return err
+	}
+
+	for _, row := range table.Rows {
+		for i, column := range columns {
+			record[i] = formatValue(column.Value(row))
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// formatValue приводит значение колонки к тексту CSV
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return csvText(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case *float64:
+		if v == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*v, 'f', -1, 64)
+	case time.Time:
+		return v.In(time.Local).Format(TimeLayout)
+	}
+	return ""
+}
+
+// csvText защищает текст ячейки от выполнения как формулы (CSV injection): Excel считает
+// формулой ячейку, которая начинается с =, +, -, @, табуляции или возврата каретки.
+// Перед таким текстом ставится апостроф, и ячейка остается текстом.
+func csvText(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,62 @@
+package export
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"photo-sync-server/storage"
+)
+
+func TestCSVText(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"", ""},
+		{"0011067", "0011067"},
+		{"v=1;counter=0011067", "v=1;counter=0011067"},
+		{"=HYPERLINK(\"http://x\")", "'=HYPERLINK(\"http://x\")"},
+		{"+79990000000", "'+79990000000"},
+		{"-1+2", "'-1+2"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\t=1", "'\t=1"},
+		{"\r=1", "'\r=1"},
+	}
+	for _, tt := range tests {
+		if got := csvText(tt.value); got != tt.want {
+			t.Errorf("csvText(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestWriteCSVEscapesText(t *testing.T) {
+	reading, delta := 12.5, -3.0
+	table := &Table{
+		Registers: []string{"main"},
+		Rows: []Row{{
+			Counter: "=cmd",
+			Photo: &storage.PhotoInfo{
+				Path:        "@cmd/2025/01/photo.jpg",
+				Date:        time.Date(2025, 1, 10, 10, 0, 0, 0, time.Local),
+				UserComment: "=1+1",
+				Reading:     &reading,
+			},
+			Deltas: map[string]*float64{"main": &delta},
+		}},
+	}
+
+	var out bytes.Buffer
+	if err := WriteCSV(&out, table); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines", len(lines))
+	}
+	want := "'=cmd,2025-01-10 10:00:00,'@cmd/2025/01/photo.jpg,0,,'=1+1,12.5,-3"
+	if lines[1] != want {
+		t.Errorf("row = %q, want %q", lines[1], want)
+	}
+}
@@ -0,0 +1,217 @@
+package export
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"photo-sync-server/metadata"
+	"photo-sync-server/storage"
+)
+
+// DateLayout - формат дат фильтра (день включительно)
+const DateLayout = "2006-01-02"
+
+// Filter отбирает фото для выгрузки. Пустые поля не ограничивают выборку.
+type Filter struct {
+	Counter string    // номер счетчика в любом написании
+	From    time.Time // начало периода включительно
+	To      time.Time // конец периода, не включая
+}
+
+// ParseFilter разбирает параметры выгрузки. Даты принимаются в виде ГГГГ-ММ-ДД
+// (местное время, to - весь день включительно) или RFC3339.
+func ParseFilter(counter, from, to string) (Filter, error) {
+	filter := Filter{Counter: strings.TrimSpace(counter)}
+	if counter != "" && storage.NormalizeCounterNumber(counter) == "" {
+		return filter, fmt.Errorf("invalid counter %q", counter)
+	}
+
+	var err error
+	if from != "" {
+		if filter.From, err = parseDate(from, false); err != nil {
+			return filter, fmt.Errorf("invalid from date %q: use YYYY-MM-DD or RFC3339", from)
+		}
+	}
+	if to != "" {
+		if filter.To, err = parseDate(to, true); err != nil {
+			return filter, fmt.Errorf("invalid to date %q: use YYYY-MM-DD or RFC3339", to)
+		}
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return filter, fmt.Errorf("from date must be before to date")
+	}
+	return filter, nil
+}
+
+// parseDate разбирает дату фильтра; для конца периода дата без времени означает следующие сутки
+func parseDate(value string, end bool) (time.Time, error) {
+	if date, err := time.ParseInLocation(DateLayout, value, time.Local); err == nil {
+		if end {
+			date = date.AddDate(0, 0, 1)
+		}
+		return date, nil
+	}
+	date, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if end {
+		// Момент RFC3339 входит в период
+		date = date.Add(time.Nanosecond)
+	}
+	return date, nil
+}
+
+// Match проверяет, попадает ли фото счетчика в выборку
+func (f Filter) Match(counterNumber string, photo *storage.PhotoInfo) bool {
+	if f.Counter != "" && storage.NormalizeCounterNumber(counterNumber) != storage.NormalizeCounterNumber(f.Counter) {
+		return false
+	}
+	if !f.From.IsZero() && photo.Date.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !photo.Date.Before(f.To) {
+		return false
+	}
+	return true
+}
+
+// FileName возвращает имя файла выгрузки с расширением ext, например photos_0011067_2025-01-01_2025-12-31.csv
+func (f Filter) FileName(ext string) string {
+	name := "photos"
+	if f.Counter != "" {
+		name += "_" + storage.NormalizeCounterNumber(f.Counter)
+	}
+	if !f.From.IsZero() {
+		name += "_" + f.From.In(time.Local).Format(DateLayout)
+	}
+	if !f.To.IsZero() {
+		name += "_" + f.To.Add(-time.Nanosecond).In(time.Local).Format(DateLayout)
+	}
+	return name + "." + ext
+}
+
+// Row - фото в выгрузке
+type Row struct {
+	Counter string
+	Photo   *storage.PhotoInfo
+	Deltas  map[string]*float64 // расход по регистрам с предыдущего показания счетчика
+}
+
+// Table - отобранные фото по счетчику и дате и регистры показаний, которые в них встречаются
+type Table struct {
+	Registers []string
+	Rows      []Row
+}
+
+// Select отбирает фото из индекса. Строки отсортированы по счетчику и дате. Расход считается
+// по всей истории счетчика, поэтому у первого фото периода он отсчитывается от показания до периода.
+func Select(indexer *storage.Indexer, filter Filter) *Table {
+	// История счетчика для расчета расхода: счетчик -> все его фото
+	history := make(map[string][]*storage.PhotoInfo)
+	var rows []Row
+	if filter.Counter != "" {
+		// Фото одного счетчика читаются по индексу by_counter и служат его историей
+		key := storage.NormalizeCounterNumber(filter.Counter)
+		history[key] = indexer.GetPhotosByCounter(key)
+		for _, photo := range history[key] {
+			if filter.Match(key, photo) {
+				rows = append(rows, Row{Counter: key, Photo: photo})
+			}
+		}
+	} else {
+		indexer.ForEachPhotoInRange(filter.From, filter.To, func(counterNumber string, photo *storage.PhotoInfo) {
+			if filter.Match(counterNumber, photo) {
+				rows = append(rows, Row{Counter: counterNumber, Photo: photo})
+			}
+		})
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].Counter != rows[j].Counter {
+			return rows[i].Counter < rows[j].Counter
+		}
+		if !rows[i].Photo.Date.Equal(rows[j].Photo.Date) {
+			return rows[i].Photo.Date.Before(rows[j].Photo.Date)
+		}
+		return rows[i].Photo.Path < rows[j].Photo.Path
+	})
+
+	photos := make([]*storage.PhotoInfo, len(rows))
+	for i, row := range rows {
+		photos[i] = row.Photo
+	}
+	table := &Table{Registers: storage.ReadingRegisters(photos), Rows: rows}
+
+	// Расход между показаниями: счетчик -> путь фото -> регистр
+	deltas := make(map[string]map[string]map[string]*float64)
+	for i := range rows {
+		key := storage.NormalizeCounterNumber(rows[i].Counter)
+		if _, done := deltas[key]; !done {
+			if _, loaded := history[key]; !loaded {
+				history[key] = indexer.GetPhotosByCounter(key)
+			}
+			deltas[key] = counterDeltas(history[key], table.Registers)
+		}
+		rows[i].Deltas = deltas[key][rows[i].Photo.Path]
+	}
+	return table
+}
+
+// counterDeltas считает расход по регистрам для каждого фото счетчика
+func counterDeltas(photos []*storage.PhotoInfo, registers []string) map[string]map[string]*float64 {
+	result := make(map[string]map[string]*float64)
+	for _, register := range registers {
+		for _, point := range storage.ReadingSeries(photos, register) {
+			if point.Delta == nil {
+				continue
+			}
+			if result[point.Path] == nil {
+				result[point.Path] = make(map[string]*float64)
+			}
+			result[point.Path][register] = point.Delta
+		}
+	}
+	return result
+}
+
+// Column - колонка выгрузки. Value возвращает string, int64, float64, *float64 или time.Time.
+type Column struct {
+	Name  string
+	Width float64 // ширина колонки XLSX в символах
+	Value func(row Row) interface{}
+}
+
+// Columns возвращает колонки выгрузки: сведения о фото и для каждого регистра показание и расход
+func (t *Table) Columns() []Column {
+	columns := []Column{
+		{Name: "counter", Width: 16, Value: func(row Row) interface{} { return row.Counter }},
+		{Name: "date", Width: 20, Value: func(row Row) interface{} { return row.Photo.Date }},
+		{Name: "path", Width: 48, Value: func(row Row) interface{} { return row.Photo.Path }},
+		{Name: "size", Width: 10, Value: func(row Row) interface{} { return row.Photo.Size }},
+		{Name: "hash", Width: 20, Value: func(row Row) interface{} { return row.Photo.Hash }},
+		{Name: "user_comment", Width: 32, Value: func(row Row) interface{} { return row.Photo.UserComment }},
+	}
+
+	for _, register := range t.Registers {
+		register := register
+		suffix := ""
+		if register != metadata.DefaultRegister {
+			suffix = "_" + register
+		}
+		columns = append(columns,
+			Column{Name: "reading" + suffix, Width: 12, Value: func(row Row) interface{} {
+				if value, ok := row.Photo.RegisterReading(register); ok {
+					return &value
+				}
+				return (*float64)(nil)
+			}},
+			Column{Name: "consumption" + suffix, Width: 12, Value: func(row Row) interface{} {
+				return row.Deltas[register]
+			}},
+		)
+	}
+	return columns
+}
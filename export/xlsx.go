@@ -0,0 +1,200 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// XLSXContentType - MIME тип книги Excel
+const XLSXContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// xlsxSheetName - имя листа с выгрузкой
+const xlsxSheetName = "Photos"
+
+// Стили ячеек из xlsxStyles (индексы cellXfs)
+const (
+	styleDefault = 0
+	styleHeader  = 1
+	styleDate    = 2
+)
+
+// Минимальная книга Office Open XML из одного листа. Строки пишутся прямо в ячейки
+// (inlineStr), поэтому таблица общих строк не нужна и лист пишется потоком.
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>
+</Types>`
+
+	xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`
+
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+</Relationships>`
+
+	xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="` + xlsxSheetName + `" sheetId="1" r:id="rId1"/></sheets>
+<definedNames><definedName name="_xlnm._FilterDatabase" localSheetId="0" hidden="1">%s!%s</definedName></definedNames>
+</workbook>`
+
+	xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<numFmts count="1"><numFmt numFmtId="164" formatCode="yyyy\-mm\-dd\ hh:mm:ss"/></numFmts>
+<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>
+<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="3">
+<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>
+<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>
+<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+</cellXfs>
+<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>
+</styleSheet>`
+)
+
+// excelEpoch - нулевой день дат Excel (с учетом ошибки 1900 года в Excel)
+var excelEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// WriteXLSX записывает выгрузку в книгу Excel с одним листом: заголовок закреплен
+// и с автофильтром, даты съемки - даты Excel в местном времени ПК, показания - числа.
+func WriteXLSX(w io.Writer, table *Table) error {
+	columns := table.Columns()
+	ref := "A1:" + cellName(len(columns)-1, len(table.Rows))
+
+	archive := zip.NewWriter(w)
+	parts := []struct {
+		name    string
+		content string
+	}{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+		{"xl/workbook.xml", fmt.Sprintf(xlsxWorkbook, xlsxSheetName, absoluteRef(ref))},
+		{"xl/styles.xml", xlsxStyles},
+	}
+	for _, part := range parts {
+		file, err := archive.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(file, part.content); err != nil {
+			return err
+		}
+	}
+
+	sheet, err := archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	if err := writeSheet(sheet, table, columns, ref); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// writeSheet пишет лист построчно
+func writeSheet(w io.Writer, table *Table, columns []Column, ref string) error {
+	out := bufio.NewWriter(w)
+	out.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	out.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	out.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+
+	out.WriteString(`<cols>`)
+	for i, column := range columns {
+		fmt.Fprintf(out, `<col min="%d" max="%d" width="%s" customWidth="1"/>`, i+1, i+1, strconv.FormatFloat(column.Width, 'f', -1, 64))
+	}
+	out.WriteString(`</cols><sheetData>`)
+
+	out.WriteString(`<row r="1">`)
+	for i, column := range columns {
+		writeStringCell(out, cellName(i, 0), column.Name, styleHeader)
+	}
+	out.WriteString(`</row>`)
+
+	for r, row := range table.Rows {
+		fmt.Fprintf(out, `<row r="%d">`, r+2)
+		for i, column := range columns {
+			writeCell(out, cellName(i, r+1), column.Value(row))
+		}
+		out.WriteString(`</row>`)
+	}
+
+	fmt.Fprintf(out, `</sheetData><autoFilter ref="%s"/></worksheet>`, ref)
+	return out.Flush()
+}
+
+// writeCell пишет ячейку по типу значения; пустые значения пропускаются
+func writeCell(out *bufio.Writer, name string, value interface{}) {
+	switch v := value.(type) {
+	case string:
+		if v != "" {
+			writeStringCell(out, name, v, styleDefault)
+		}
+	case int64:
+		fmt.Fprintf(out, `<c r="%s"><v>%d</v></c>`, name, v)
+	case float64:
+		writeNumberCell(out, name, v, styleDefault)
+	case *float64:
+		if v != nil {
+			writeNumberCell(out, name, *v, styleDefault)
+		}
+	case time.Time:
+		if !v.IsZero() {
+			writeNumberCell(out, name, excelDate(v), styleDate)
+		}
+	}
+}
+
+func writeNumberCell(out *bufio.Writer, name string, value float64, style int) {
+	fmt.Fprintf(out, `<c r="%s" s="%d"><v>%s</v></c>`, name, style, strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+func writeStringCell(out *bufio.Writer, name, value string, style int) {
+	fmt.Fprintf(out, `<c r="%s" s="%d" t="inlineStr"><is><t xml:space="preserve">`, name, style)
+	// EscapeText заменяет недопустимые в XML символы на U+FFFD
+	xml.EscapeText(out, []byte(value))
+	out.WriteString(`</t></is></c>`)
+}
+
+// excelDate переводит время в дату Excel (дни с excelEpoch) по часам местного времени
+func excelDate(t time.Time) float64 {
+	local := t.In(time.Local)
+	wall := time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), local.Second(), 0, time.UTC)
+	return float64(wall.Sub(excelEpoch)) / float64(24*time.Hour)
+}
+
+// cellName возвращает адрес ячейки: столбец и строка с нуля, (0, 0) -> A1
+func cellName(column, row int) string {
+	name := ""
+	for column++; column > 0; column = (column - 1) / 26 {
+		name = string(rune('A'+(column-1)%26)) + name
+	}
+	return name + strconv.Itoa(row+1)
+}
+
+// absoluteRef превращает A1:H10 в $A$1:$H$10 для определенного имени
+func absoluteRef(ref string) string {
+	cells := strings.Split(ref, ":")
+	for i, cell := range cells {
+		split := strings.IndexAny(cell, "0123456789")
+		cells[i] = "$" + cell[:split] + "$" + cell[split:]
+	}
+	return strings.Join(cells, ":")
+}
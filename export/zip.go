@@ -0,0 +1,201 @@
+package export
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"photo-sync-server/storage"
+)
+
+// ManifestName - имя списка файлов в архиве
+const ManifestName = "manifest.csv"
+
+// Состояния файлов в manifest.csv
+const (
+	zipFileOK      = "ok"
+	zipFileMissing = "missing"       // файла нет на диске
+	zipFileChanged = "hash_mismatch" // содержимое не совпадает с хешем из индекса
+	zipFileFailed  = "read_error"    // файл не удалось дочитать, запись в архиве неполная
+)
+
+// Итоговая строка manifest.csv: все ли фото выборки попали в архив без ошибок
+const (
+	zipExportComplete   = "export_complete"
+	zipExportIncomplete = "export_incomplete"
+)
+
+// ZIPReport - итог записи архива
+type ZIPReport struct {
+	Files   int // фото в архиве
+	Missing int // фото из индекса, которых нет на диске
+	Changed int // фото, не совпавшие с хешем из индекса
+	Failed  int // фото, которые не удалось дочитать
+}
+
+// Complete сообщает, что все фото выборки записаны в архив целиком и совпали с индексом
+func (r *ZIPReport) Complete() bool {
+	return r.Missing == 0 && r.Changed == 0 && r.Failed == 0
+}
+
+// zipManifestRow - строка manifest.csv
+type zipManifestRow struct {
+	name   string
+	row    Row
+	size   int64
+	hash   string
+	status string
+}
+
+// WriteZIP записывает фото выгрузки в ZIP потоком, не создавая временных файлов.
+// Фото лежат в папках счетчиков и называются по счетчику и дате съемки:
+// 0011067128/0011067128_20250304_101112.jpg. В конце архива - manifest.csv
+// с исходными путями и SHA-256 фактически записанных данных; последняя строка
+// manifest.csv - export_complete или export_incomplete.
+// Ошибка возвращается, только если не удалось писать в w.
+func WriteZIP(w io.Writer, table *Table, files *storage.FileManager) (*ZIPReport, error) {
+	archive := zip.NewWriter(w)
+	// JPEG почти не сжимается, поэтому сжатие самое быстрое
+	archive.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, flate.BestSpeed)
+	})
+
+	report := &ZIPReport{}
+	names := make(map[string]bool)
+	manifest := make([]zipManifestRow, 0, len(table.Rows))
+	for _, row := range table.Rows {
+		entry := zipManifestRow{row: row}
+
+		file, err := files.OpenFile(row.Photo.Path)
+		if err != nil {
+			entry.status = zipFileMissing
+			report.Missing++
+			manifest = append(manifest, entry)
+			continue
+		}
+
+		entry.name = zipEntryName(row, names)
+		entry.size, entry.hash, entry.status, err = writeZIPEntry(archive, entry.name, row.Photo, file)
+		file.Close()
+		if err != nil {
+			return report, err
+		}
+		report.Files++
+		switch entry.status {
+		case zipFileChanged:
+			report.Changed++
+		case zipFileFailed:
+			report.Failed++
+		}
+		manifest = append(manifest, entry)
+	}
+
+	if err := writeZIPManifest(archive, manifest, report); err != nil {
+		return report, err
+	}
+	return report, archive.Close()
+}
+
+// writeZIPEntry копирует файл в архив и считает SHA-256 записанных данных.
+// Ошибка чтения файла отмечается в состоянии, ошибка записи в архив возвращается.
+func writeZIPEntry(archive *zip.Writer, name string, photo *storage.PhotoInfo, file io.Reader) (int64, string, string, error) {
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: photo.Date.In(time.Local)}
+	entry, err := archive.CreateHeader(header)
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	hasher := sha256.New()
+	size, status := int64(0), zipFileOK
+	buf := make([]byte, 256*1024)
+	for {
+		n, readErr := file.Read(buf)
+		if n > 0 {
+			if _, err := entry.Write(buf[:n]); err != nil {
+				return size, "", "", err
+			}
+			hasher.Write(buf[:n])
+			size += int64(n)
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			status = zipFileFailed
+			break
+		}
+	}
+
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	if status == zipFileOK && photo.Hash != "" && !strings.EqualFold(hash, photo.Hash) {
+		status = zipFileChanged
+	}
+	return size, hash, status, nil
+}
+
+// writeZIPManifest записывает manifest.csv последним файлом архива.
+// Итоговая строка содержит только состояние, по ней видно, что manifest дописан до конца.
+func writeZIPManifest(archive *zip.Writer, manifest []zipManifestRow, report *ZIPReport) error {
+	file, err := archive.CreateHeader(&zip.FileHeader{Name: ManifestName, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(file, "\xEF\xBB\xBF"); err != nil {
+		return err
+	}
+
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"file", "counter", "date", "path", "size", "sha256", "index_sha256", "status"})
+	for _, entry := range manifest {
+		size := ""
+		if entry.name != "" {
+			size = strconv.FormatInt(entry.size, 10)
+		}
+		writer.Write([]string{
+			csvText(entry.name),
+			csvText(entry.row.Counter),
+			formatValue(entry.row.Photo.Date),
+			csvText(entry.row.Photo.Path),
+			size,
+			entry.hash,
+			entry.row.Photo.Hash,
+			entry.status,
+		})
+	}
+	status := zipExportComplete
+	if !report.Complete() {
+		status = zipExportIncomplete
+	}
+	writer.Write([]string{"", "", "", "", "", "", "", status})
+	writer.Flush()
+	return writer.Error()
+}
+
+// zipEntryName возвращает имя фото в архиве по счетчику и дате съемки. Если за ту же
+// секунду есть несколько фото, к имени добавляется начало хеша, а затем номер.
+func zipEntryName(row Row, used map[string]bool) string {
+	counter := storage.NormalizeCounterNumber(row.Counter)
+	if counter == "" {
+		counter = "unknown"
+	}
+	ext := strings.ToLower(filepath.Ext(row.Photo.Path))
+	base := counter + "/" + counter + "_" + row.Photo.Date.In(time.Local).Format("20060102_150405")
+
+	name := base + ext
+	if used[name] && len(row.Photo.Hash) >= 8 {
+		name = base + "_" + row.Photo.Hash[:8] + ext
+	}
+	for i := 2; used[name]; i++ {
+		name = fmt.Sprintf("%s_%d%s", base, i, ext)
+	}
+	used[name] = true
+	return name
+}
@@ -0,0 +1,94 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"photo-sync-server/storage"
+)
+
+// readZIPManifest возвращает строки manifest.csv из архива
+func readZIPManifest(t *testing.T, data []byte) [][]string {
+	t.Helper()
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range archive.File {
+		if file.Name != ManifestName {
+			continue
+		}
+		r, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		content, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(string(content), "\xEF\xBB\xBF"))).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rows
+	}
+	t.Fatal("manifest.csv not found")
+	return nil
+}
+
+func TestWriteZIPStatus(t *testing.T) {
+	dir := t.TempDir()
+	layout, err := storage.ParseLayout(storage.DefaultLayout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := storage.NewFileManager(dir, layout)
+	if err := os.MkdirAll(filepath.Join(dir, "0011067"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "0011067", "a.jpg"), []byte("photo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	date := time.Date(2025, 3, 4, 10, 11, 12, 0, time.Local)
+	present := Row{Counter: "0011067", Photo: &storage.PhotoInfo{Path: "0011067/a.jpg", Date: date}}
+	missing := Row{Counter: "0011067", Photo: &storage.PhotoInfo{Path: "0011067/b.jpg", Date: date.Add(time.Hour)}}
+
+	tests := []struct {
+		name   string
+		rows   []Row
+		status string
+	}{
+		{"complete", []Row{present}, zipExportComplete},
+		{"missing file", []Row{present, missing}, zipExportIncomplete},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			report, err := WriteZIP(&out, &Table{Rows: tt.rows}, files)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Complete() != (tt.status == zipExportComplete) {
+				t.Errorf("report = %+v", report)
+			}
+
+			rows := readZIPManifest(t, out.Bytes())
+			if len(rows) != len(tt.rows)+2 {
+				t.Fatalf("got %d manifest rows, want %d", len(rows), len(tt.rows)+2)
+			}
+			last := rows[len(rows)-1]
+			if got := last[len(last)-1]; got != tt.status {
+				t.Errorf("manifest status = %q, want %q", got, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"photo-sync-server/security"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Заголовки подписи запросов устройства
+const (
+	HeaderTimestamp     = "X-Sync-Timestamp"
+	HeaderSignature     = "X-Sync-Signature"
+	HeaderContentSHA256 = "X-Content-SHA256"
+	HeaderAdminKey      = "X-Admin-Key"
+)
+
+// maxClockSkew - допустимое расхождение часов устройства и сервера
+const maxClockSkew = 5 * time.Minute
+
+// maxSignedBodySize - размер тела, которое middleware читает целиком для проверки хеша
+const maxSignedBodySize = 1 << 20
+
+// contentHashKey - ключ контекста с заявленным устройством SHA256 содержимого
+const contentHashKey = "contentSHA256"
+
+// signedRequestKey - ключ контекста с подписью запроса, которая проверяется в обработчике
+const signedRequestKey = "signedRequest"
+
+// AdminCookieName - cookie, которую галерея получает через POST /admin/login
+const AdminCookieName = "photo_sync_admin"
+
+// adminCookieMaxAge - срок действия cookie администратора в секундах
+const adminCookieMaxAge = 30 * 24 * 60 * 60
+
+// RequireAdmin пропускает только запросы с ключом администратора.
+// Ключ передается в заголовке Authorization: Bearer или X-Admin-Key; браузер галереи
+// вместо ключа присылает HttpOnly cookie. В адресе ключ не принимается, чтобы он
+// не попадал в журнал запросов и историю браузера.
+// Если включен admin_localhost, запросы с этого ПК проходят без ключа.
+// Ответы управляющих запросов не открываются другим сайтам через CORS.
+func (h *Handlers) RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.Writer.Header()
+		header.Del("Access-Control-Allow-Origin")
+		header.Del("Access-Control-Allow-Credentials")
+
+		if h.config.AdminLocalhost && isLocalRequest(c.Request) {
+			c.Next()
+			return
+		}
+
+		if key := adminKeyHeader(c); key != "" && security.EqualKeys(key, h.config.AdminKey) {
+			c.Next()
+			return
+		}
+		if cookie, err := c.Cookie(AdminCookieName); err == nil && security.EqualKeys(cookie, security.AdminCookieValue(h.config.AdminKey)) {
+			c.Next()
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "admin key is required"})
+	}
+}
+
+// AdminLoginHandler выдает браузеру HttpOnly cookie администратора (маршрут закрыт RequireAdmin,
+// ключ приходит в заголовке). Так галерея передает ключ один раз, а не в адресе каждого фото.
+func (h *Handlers) AdminLoginHandler(c *gin.Context) {
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(AdminCookieName, security.AdminCookieValue(h.config.AdminKey), adminCookieMaxAge, "/", "", h.config.TLS, true)
+	c.Status(http.StatusNoContent)
+}
+
+// AdminLogoutHandler удаляет cookie администратора
+func (h *Handlers) AdminLogoutHandler(c *gin.Context) {
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(AdminCookieName, "", -1, "/", "", h.config.TLS, true)
+	c.Status(http.StatusNoContent)
+}
+
+// adminKeyHeader возвращает ключ администратора из заголовков запроса
+func adminKeyHeader(c *gin.Context) string {
+	if key := c.GetHeader(HeaderAdminKey); key != "" {
+		return key
+	}
+	if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+// signedRequest - заголовки подписи запроса устройства
+type signedRequest struct {
+	secret      string
+	token       string
+	timestamp   string
+	signature   string
+	contentHash string
+}
+
+// verify проверяет подпись с дополнительно подписанными полями формы
+func (r *signedRequest) verify(c *gin.Context, fields ...string) bool {
+	return security.VerifySignature(r.secret, r.signature, c.Request.Method, c.Request.URL.Path, r.token, r.timestamp, r.contentHash, fields...)
+}
+
+// RequireSignature проверяет HMAC подпись запроса секретом сессии.
+// Устройство подписывает метод, путь, токен, время и SHA256 содержимого (см. security.CanonicalRequest).
+// Для небольших тел (streamBody=false) хеш сверяется сразу; для потоковых загрузок
+// обработчик сверяет его сам после приема данных (см. verifyContentHash).
+func (h *Handlers) RequireSignature(streamBody bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		request, ok := h.readSignature(c)
+		if !ok {
+			return
+		}
+
+		if !request.verify(c) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid request signature"})
+			return
+		}
+
+		if !streamBody && !checkBodyHash(c, request.contentHash) {
+			return
+		}
+
+		c.Set(contentHashKey, request.contentHash)
+		c.Next()
+	}
+}
+
+// RequireFormSignature - вариант RequireSignature для запросов, где подпись покрывает и поля
+// с номером счетчика, датой и показаниями: форма /sync и Upload-Metadata в POST /uploads.
+// Поля известны только после разбора запроса, поэтому здесь проверяются наличие заголовков и время,
+// а подпись сверяет обработчик (см. verifyFormSignature). Для небольших тел (streamBody=false)
+// хеш тела сверяется сразу, как в RequireSignature.
+func (h *Handlers) RequireFormSignature(streamBody bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		request, ok := h.readSignature(c)
+		if !ok {
+			return
+		}
+		if !streamBody && !checkBodyHash(c, request.contentHash) {
+			return
+		}
+
+		c.Set(signedRequestKey, request)
+		c.Set(contentHashKey, request.contentHash)
+		c.Next()
+	}
+}
+
+// checkBodyHash читает небольшое тело запроса и сверяет его SHA256 с подписанным.
+// При ошибке запрос прерывается; тело остается доступным обработчику.
+func checkBodyHash(c *gin.Context, contentHash string) bool {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxSignedBodySize+1))
+	if err != nil || len(body) > maxSignedBodySize {
+		c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request body is too large"})
+		return false
+	}
+	sum := sha256.Sum256(body)
+	if hex.EncodeToString(sum[:]) != contentHash {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "content hash mismatch"})
+		return false
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	return true
+}
+
+// readSignature читает заголовки подписи и проверяет сессию и время запроса.
+// При ошибке запрос прерывается.
+func (h *Handlers) readSignature(c *gin.Context) (*signedRequest, bool) {
+	token := c.Query("token")
+	if token == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+		return nil, false
+	}
+
+	session, exists := h.sessionStore.Get(token)
+	if !exists {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return nil, false
+	}
+
+	request := &signedRequest{
+		secret:      session.Secret,
+		token:       token,
+		timestamp:   c.GetHeader(HeaderTimestamp),
+		signature:   c.GetHeader(HeaderSignature),
+		contentHash: strings.ToLower(c.GetHeader(HeaderContentSHA256)),
+	}
+	if request.timestamp == "" || request.signature == "" || request.contentHash == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "request signature is required"})
+		return nil, false
+	}
+
+	unix, err := strconv.ParseInt(request.timestamp, 10, 64)
+	if err != nil || absDuration(time.Since(time.Unix(unix, 0))) > maxClockSkew {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "request timestamp is invalid or expired"})
+		return nil, false
+	}
+	return request, true
+}
+
+// verifyFormSignature проверяет подпись запроса, прошедшего RequireFormSignature, с полями формы
+func verifyFormSignature(c *gin.Context, fields ...string) bool {
+	value, exists := c.Get(signedRequestKey)
+	if !exists {
+		return false
+	}
+	request, ok := value.(*signedRequest)
+	return ok && request.verify(c, fields...)
+}
+
+// verifyContentHash сверяет хеш принятых данных с подписанным устройством
+func verifyContentHash(c *gin.Context, actual string) bool {
+	return c.GetString(contentHashKey) == actual
+}
+
+// isLocalRequest проверяет, что запрос отправлен с этого ПК страницей самого сервера.
+// Кроме адреса клиента проверяются Host (адрес сайта после DNS rebinding - не localhost)
+// и Origin: чужая страница в браузере на этом ПК тоже подключается с localhost.
+func isLocalRequest(r *http.Request) bool {
+	if !isLoopback(r.RemoteAddr) || !isLoopbackHost(r.Host) {
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && isLoopbackHost(u.Host)
+}
+
+// isLoopbackHost проверяет, что имя хоста (с портом или без) - localhost или loopback адрес
+func isLoopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// isLoopback проверяет, что запрос пришел с этого же компьютера
+func isLoopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// absDuration возвращает модуль длительности
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
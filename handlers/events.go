@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"photo-sync-server/models"
+	"photo-sync-server/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// Типы событий потока прогресса
+const (
+	EventStatus   = "status"   // текущее состояние сессии (первое событие и прочие изменения)
+	EventComplete = "complete" // синхронизация завершена
+	EventClosed   = "closed"   // сессия удалена или истекла, поток закрывается
+	// Для обработанного файла тип события совпадает с его статусом: accepted, duplicate или error
+)
+
+// keepAliveInterval - как часто отправлять пустые сообщения, чтобы прокси не закрыли соединение
+const keepAliveInterval = 25 * time.Second
+
+// wsWriteTimeout ограничивает запись одного сообщения в WebSocket
+const wsWriteTimeout = 10 * time.Second
+
+// upgrader переводит соединение в WebSocket. Страница прогресса может открываться
+// с другого сайта, поэтому Origin не проверяется, как и в CORS для /status.
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// progressEvent - одно событие для отправки клиенту
+type progressEvent struct {
+	Name string
+	Data gin.H
+}
+
+// progressEvents превращает изменение сессии в события для клиента.
+// previous - статус сессии из предыдущего отправленного события.
+func progressEvents(event storage.SessionEvent, previous models.SyncStatus) []progressEvent {
+	data := statusFields(&event.Session)
+
+	var events []progressEvent
+	if event.Result != nil {
+		data["lastResult"] = event.Result
+		events = append(events, progressEvent{Name: event.Result.Status, Data: data})
+	} else if event.Session.Status != models.StatusCompleted || previous == models.StatusCompleted {
+		events = append(events, progressEvent{Name: EventStatus, Data: data})
+	}
+
+	if event.Session.Status == models.StatusCompleted && previous != models.StatusCompleted {
+		events = append(events, progressEvent{Name: EventComplete, Data: statusFields(&event.Session)})
+	}
+	return events
+}
+
+// subscribeProgress подписывается на сессию из параметра token и возвращает ее текущее состояние
+func (h *Handlers) subscribeProgress(c *gin.Context) (models.Session, <-chan storage.SessionEvent, func(), bool) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+		return models.Session{}, nil, nil, false
+	}
+
+	events, cancel, ok := h.sessionStore.Subscribe(token)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return models.Session{}, nil, nil, false
+	}
+	session, ok := h.sessionStore.Snapshot(token)
+	if !ok {
+		cancel()
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return models.Session{}, nil, nil, false
+	}
+	return session, events, cancel, true
+}
+
+// StatusStreamHandler отправляет прогресс сессии через Server-Sent Events.
+// Первым приходит событие status с текущим состоянием, дальше - каждое изменение сессии.
+func (h *Handlers) StatusStreamHandler(c *gin.Context) {
+	session, events, cancel, ok := h.subscribeProgress(c)
+	if !ok {
+		return
+	}
+	defer cancel()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// Отключаем буферизацию в nginx, если сервер стоит за ним
+	c.Header("X-Accel-Buffering", "no")
+
+	c.SSEvent(EventStatus, statusFields(&session))
+	c.Writer.Flush()
+
+	previous := session.Status
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-keepAlive.C:
+			io.WriteString(w, ": keep-alive\n\n")
+			return true
+		case event, open := <-events:
+			if !open {
+				c.SSEvent(EventClosed, gin.H{"status": "closed"})
+				return false
+			}
+			for _, e := range progressEvents(event, previous) {
+				c.SSEvent(e.Name, e.Data)
+			}
+			previous = event.Session.Status
+			return true
+		}
+	})
+}
+
+// StatusWebSocketHandler отправляет те же события, что и StatusStreamHandler, через WebSocket.
+// Каждое сообщение - JSON вида {"event": "...", "data": {...}}.
+func (h *Handlers) StatusWebSocketHandler(c *gin.Context) {
+	session, events, cancel, ok := h.subscribeProgress(c)
+	if !ok {
+		return
+	}
+	defer cancel()
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// Upgrade сам отвечает клиенту ошибкой
+		return
+	}
+	defer conn.Close()
+
+	// Клиент ничего не присылает, но чтение нужно, чтобы заметить закрытие соединения
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(name string, data gin.H) bool {
+		conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		return conn.WriteJSON(gin.H{"event": name, "data": data}) == nil
+	}
+
+	if !send(EventStatus, statusFields(&session)) {
+		return
+	}
+
+	previous := session.Status
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-keepAlive.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+		case event, open := <-events:
+			if !open {
+				send(EventClosed, gin.H{"status": "closed"})
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "session closed"), time.Now().Add(wsWriteTimeout))
+				return
+			}
+			for _, e := range progressEvents(event, previous) {
+				if !send(e.Name, e.Data) {
+					return
+				}
+			}
+			previous = event.Session.Status
+		}
+	}
+}
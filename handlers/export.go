@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"photo-sync-server/export"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ExportCSVHandler выгружает фото и показания в CSV.
+// Параметры counter, from и to (ГГГГ-ММ-ДД или RFC3339) ограничивают выборку.
+func (h *Handlers) ExportCSVHandler(c *gin.Context) {
+	h.exportTable(c, "csv", "text/csv; charset=utf-8", export.WriteCSV)
+}
+
+// ExportXLSXHandler выгружает фото и показания в книгу Excel с теми же параметрами, что и CSV
+func (h *Handlers) ExportXLSXHandler(c *gin.Context) {
+	h.exportTable(c, "xlsx", export.XLSXContentType, export.WriteXLSX)
+}
+
+// ExportZIPHandler отдает фото с параметрами counter, from и to одним ZIP архивом
+// с manifest.csv. Архив пишется прямо в ответ, без временных файлов, поэтому 200
+// отправляется до чтения фото. Итог передается в HTTP trailer после архива:
+// X-Export-Status (complete, incomplete - есть фото missing, hash_mismatch или
+// read_error в manifest.csv, failed - архив оборван), X-Export-Files,
+// X-Export-Missing, X-Export-Changed и X-Export-Failed. Оборванный архив без
+// manifest.csv или без итоговой строки export_complete считается неполным.
+func (h *Handlers) ExportZIPHandler(c *gin.Context) {
+	filter, ok := exportFilter(c)
+	if !ok {
+		return
+	}
+	table := export.Select(h.indexer, filter)
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filter.FileName("zip")}))
+	c.Header("Trailer", "X-Export-Status, X-Export-Files, X-Export-Missing, X-Export-Changed, X-Export-Failed")
+	c.Status(http.StatusOK)
+	report, err := export.WriteZIP(c.Writer, table, h.fileManager)
+
+	status := "complete"
+	switch {
+	case err != nil:
+		status = "failed"
+		fmt.Printf("Warning: ZIP export interrupted after %d photos: %v\n", report.Files, err)
+	case !report.Complete():
+		status = "incomplete"
+		fmt.Printf("Warning: ZIP export: %d photos missing on disk, %d changed since indexing, %d unreadable\n",
+			report.Missing, report.Changed, report.Failed)
+	}
+	// Значения trailer можно задать и после тела: они уходят после последнего блока chunked
+	trailer := c.Writer.Header()
+	trailer.Set("X-Export-Status", status)
+	trailer.Set("X-Export-Files", strconv.Itoa(report.Files))
+	trailer.Set("X-Export-Missing", strconv.Itoa(report.Missing))
+	trailer.Set("X-Export-Changed", strconv.Itoa(report.Changed))
+	trailer.Set("X-Export-Failed", strconv.Itoa(report.Failed))
+}
+
+// exportTable отбирает фото по параметрам запроса и записывает их в ответ функцией write
+func (h *Handlers) exportTable(c *gin.Context, ext, contentType string, write func(io.Writer, *export.Table) error) {
+	filter, ok := exportFilter(c)
+	if !ok {
+		return
+	}
+	table := export.Select(h.indexer, filter)
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filter.FileName(ext)}))
+	c.Status(http.StatusOK)
+	if err := write(c.Writer, table); err != nil {
+		// Заголовки уже отправлены, ошибку можно только записать в лог
+		fmt.Printf("Warning: Failed to write %s export: %v\n", ext, err)
+	}
+}
+
+// exportFilter разбирает параметры выгрузки; при ошибке отвечает 400
+func exportFilter(c *gin.Context) (export.Filter, bool) {
+	filter, err := export.ParseFilter(c.Query("counter"), c.Query("from"), c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return filter, false
+	}
+	return filter, true
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CounterSummary - краткие сведения о счетчике для списка в галерее
+type CounterSummary struct {
+	CounterNumber string    `json:"counterNumber"`
+	Photos        int       `json:"photos"`
+	TotalSize     int64     `json:"totalSize"`
+	FirstDate     time.Time `json:"firstDate"`
+	LastDate      time.Time `json:"lastDate"`
+}
+
+// CountersHandler возвращает список счетчиков из индекса с количеством фото и датами съемки.
+// Счетчики отсортированы по дате последнего фото, свежие первыми.
+func (h *Handlers) CountersHandler(c *gin.Context) {
+	stats := h.indexer.AllCounterStats()
+	result := make([]CounterSummary, 0, len(stats))
+	for counter, counterStats := range stats {
+		result = append(result, CounterSummary{
+			CounterNumber: counter,
+			Photos:        counterStats.Photos,
+			TotalSize:     counterStats.TotalSize,
+			FirstDate:     counterStats.FirstDate,
+			LastDate:      counterStats.LastDate,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].LastDate.Equal(result[j].LastDate) {
+			return result[i].LastDate.After(result[j].LastDate)
+		}
+		return result[i].CounterNumber < result[j].CounterNumber
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"counters": result,
+		"total":    len(result),
+	})
+}
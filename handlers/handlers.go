@@ -1,20 +1,23 @@
 package handlers
 
 import (
-	"bytes"
 	"crypto/rand"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"path/filepath"
+	"sort"
 	"time"
 
+	"photo-sync-server/config"
+	"photo-sync-server/metadata"
 	"photo-sync-server/models"
 	"photo-sync-server/storage"
 
 	"github.com/gin-gonic/gin"
-	"github.com/rwcarlsen/goexif/exif"
 )
 
 // Handlers содержит все обработчики HTTP запросов
@@ -23,19 +26,29 @@ type Handlers struct {
 	fileManager    *storage.FileManager
 	indexer        *storage.Indexer
 	duplicateCheck *storage.DuplicateCheck
-	localIP        string
+	uploadStore    *storage.UploadStore
+	thumbnails     *storage.ThumbnailCache
+	meters         *storage.MeterRegistry
+	config         *config.Config
+	addresses      []string
 	port           int
+	tlsFingerprint string
 }
 
 // NewHandlers создает новый набор обработчиков
-func NewHandlers(sessionStore *storage.SessionStore, fileManager *storage.FileManager, indexer *storage.Indexer, duplicateCheck *storage.DuplicateCheck, localIP string, port int) *Handlers {
+func NewHandlers(cfg *config.Config, sessionStore *storage.SessionStore, fileManager *storage.FileManager, indexer *storage.Indexer, duplicateCheck *storage.DuplicateCheck, uploadStore *storage.UploadStore, thumbnails *storage.ThumbnailCache, meters *storage.MeterRegistry, addresses []string, tlsFingerprint string) *Handlers {
 	return &Handlers{
 		sessionStore:   sessionStore,
 		fileManager:    fileManager,
 		indexer:        indexer,
 		duplicateCheck: duplicateCheck,
-		localIP:        localIP,
-		port:           port,
+		uploadStore:    uploadStore,
+		thumbnails:     thumbnails,
+		meters:         meters,
+		config:         cfg,
+		addresses:      addresses,
+		port:           cfg.Port(),
+		tlsFingerprint: tlsFingerprint,
 	}
 }
 
@@ -51,17 +64,29 @@ func generateShortToken() string {
 
 // StartHandler обрабатывает запрос на создание сессии
 func (h *Handlers) StartHandler(c *gin.Context) {
-	token := generateShortToken()
-	_ = h.sessionStore.Create(token) // Создаем сессию
+	pairing, err := h.CreatePairing()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create session"})
+		return
+	}
 
-	url := fmt.Sprintf("http://%s:%d/sync?token=%s", h.localIP, h.port, token)
+	response := gin.H{
+		"token":     pairing.Token,
+		"secret":    pairing.Secret,
+		"url":       pairing.URL,
+		"localIP":   h.primaryAddress(),
+		"addresses": h.addresses,
+		"urls":      pairing.URLs,
+		"port":      h.port,
+		"qrPng":     "/start/qr.png?token=" + pairing.Token,
+		"qrSvg":     "/start/qr.svg?token=" + pairing.Token,
+	}
+	// Отпечаток сертификата позволяет приложению закрепить самоподписанный сертификат
+	if pairing.CertFingerprint != "" {
+		response["certFingerprint"] = pairing.CertFingerprint
+	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"token":   token,
-		"url":     url,
-		"localIP": h.localIP,
-		"port":    h.port,
-	})
+	c.JSON(http.StatusOK, response)
 }
 
 // InitHandler обрабатывает инициализацию синхронизации
@@ -113,106 +138,362 @@ func (h *Handlers) SyncHandler(c *gin.Context) {
 		return
 	}
 
-	// Получаем файл из multipart/form-data
-	file, err := c.FormFile("photo")
+	// Ограничиваем размер запроса, чтобы не принять файл больше max_upload_size
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.config.MaxUploadSize+maxFormOverhead)
+
+	// Читаем multipart/form-data потоком: фото сразу пишется во временный файл,
+	// поэтому память не зависит от размера и количества параллельных загрузок
+	reader, err := c.Request.MultipartReader()
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "multipart/form-data is required"})
+		return
+	}
+
+	var incoming *storage.IncomingFile
+	var meta photoMeta
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			h.fileManager.DiscardIncoming(incoming)
+			if isRequestTooLarge(err) {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "photo exceeds max upload size"})
+				return
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read multipart body"})
+			return
+		}
+
+		switch part.FormName() {
+		case "photo":
+			if incoming != nil {
+				part.Close()
+				continue
+			}
+			// Читаем на байт больше лимита, чтобы отличить файл ровно лимитного размера от большего
+			incoming, err = h.fileManager.ReceiveStream(io.LimitReader(part, h.config.MaxUploadSize+1))
+			if err == nil && incoming.Size > h.config.MaxUploadSize {
+				h.fileManager.DiscardIncoming(incoming)
+				err = &http.MaxBytesError{Limit: h.config.MaxUploadSize}
+			}
+			if err != nil {
+				part.Close()
+				if isRequestTooLarge(err) {
+					c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "photo exceeds max upload size"})
+					return
+				}
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read file"})
+				return
+			}
+		case "counterNumber":
+			meta.CounterNumber = readFormValue(part)
+		case "originalName":
+			meta.OriginalName = readFormValue(part)
+		case "dateTaken":
+			meta.DateTaken = readFormValue(part)
+		case "reading":
+			meta.Reading = readFormValue(part)
+		default:
+			if register, ok := metadata.RegisterField(part.FormName()); ok {
+				if meta.Registers == nil {
+					meta.Registers = make(map[string]string)
+				}
+				meta.Registers[register] = readFormValue(part)
+			}
+		}
+		part.Close()
+	}
+
+	if incoming == nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "photo file is required"})
 		return
 	}
 
-	// Открываем файл
-	src, err := file.Open()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to open file"})
+	// Подпись покрывает поля формы: подмененные номер счетчика, дата или показание не пройдут
+	if !verifyFormSignature(c, meta.signedFields()...) {
+		h.fileManager.DiscardIncoming(incoming)
+		h.reportFileError(token, meta.OriginalName, errors.New("invalid request signature"))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid request signature"})
 		return
 	}
-	defer src.Close()
 
-	// Читаем данные файла
-	data := make([]byte, file.Size)
-	if _, err := src.Read(data); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read file"})
+	// Подпись запроса покрывает хеш фото, сверяем его с фактически принятыми данными
+	if !verifyContentHash(c, incoming.Hash) {
+		h.fileManager.DiscardIncoming(incoming)
+		h.reportFileError(token, meta.OriginalName, errors.New("content hash mismatch"))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "content hash mismatch"})
 		return
 	}
 
-	// Получаем дополнительные данные из формы
-	counterNumber := c.PostForm("counterNumber")
-	originalName := c.PostForm("originalName")
-	dateTakenStr := c.PostForm("dateTaken")
+	result, err := h.ingestPhoto(token, incoming, meta)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save file"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result.response(session))
+}
+
+// maxFormValueSize ограничивает размер текстовых полей формы
+const maxFormValueSize = 4096
+
+// maxFormOverhead - запас на заголовки частей и текстовые поля формы сверх размера фото
+const maxFormOverhead = 64 * 1024
+
+// isRequestTooLarge проверяет, что ошибка вызвана превышением max_upload_size
+func isRequestTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+// readFormValue читает значение текстового поля multipart формы
+func readFormValue(part io.Reader) string {
+	data, _ := io.ReadAll(io.LimitReader(part, maxFormValueSize))
+	return string(data)
+}
+
+// photoMeta содержит метаданные фото, переданные устройством вместе с файлом
+type photoMeta struct {
+	CounterNumber string
+	OriginalName  string
+	DateTaken     string
+	Reading       string            // показание счетчика, необязательно
+	Registers     map[string]string // показания по регистрам (поля reading.T1, reading.hot)
+}
 
-	// Парсим дату
-	dateTaken := time.Now()
-	if dateTakenStr != "" {
-		if parsed, err := time.Parse(time.RFC3339, dateTakenStr); err == nil {
-			dateTaken = parsed
+// signedFields возвращает поля формы /sync (и ключи Upload-Metadata в POST /uploads),
+// которые устройство подписывает вместе с запросом:
+// counterNumber, dateTaken, reading и reading.<регистр> по алфавиту, в виде "имя=значение"
+func (m photoMeta) signedFields() []string {
+	fields := []string{
+		"counterNumber=" + m.CounterNumber,
+		"dateTaken=" + m.DateTaken,
+		"reading=" + m.Reading,
+	}
+	for _, register := range sortedKeys(m.Registers) {
+		fields = append(fields, "reading."+register+"="+m.Registers[register])
+	}
+	return fields
+}
+
+// ingestResult описывает результат приема одного фото
+type ingestResult struct {
+	RelPath     string
+	IsDuplicate bool
+	Reason      string
+	Warnings    []string
+	Anomalies   []models.Anomaly
+}
+
+// response формирует JSON ответ на загрузку фото
+func (r *ingestResult) response(session *models.Session) gin.H {
+	if r.IsDuplicate {
+		return gin.H{
+			"success":      true,
+			"uploaded":     session.Uploaded,
+			"total":        session.Total,
+			"filepath":     r.RelPath,
+			"isDuplicate":  true,
+			"reason":       r.Reason,
+			"existingFile": r.RelPath,
 		}
 	}
+	response := gin.H{
+		"success":     true,
+		"uploaded":    session.Uploaded,
+		"total":       session.Total,
+		"filepath":    r.RelPath,
+		"isDuplicate": false,
+	}
+	if len(r.Warnings) > 0 {
+		response["warnings"] = r.Warnings
+	}
+	if len(r.Anomalies) > 0 {
+		response["anomalies"] = r.Anomalies
+	}
+	return response
+}
+
+// ingestPhoto проводит принятое фото через общий конвейер: проверка дубликатов,
+// сохранение через FileManager, индексация и обновление сессии.
+// Временный файл incoming либо переносится на место, либо удаляется.
+func (h *Handlers) ingestPhoto(token string, incoming *storage.IncomingFile, meta photoMeta) (*ingestResult, error) {
+	counterNumber := meta.CounterNumber
+	originalName := meta.OriginalName
+
+	// EXIF находится в сегменте APP1 в начале файла, читать весь файл не нужно
+	photoExif, err := metadata.Read(readEXIFHead(h.fileManager, incoming))
+	if err != nil {
+		photoExif = &metadata.Metadata{}
+	}
+
+	// Дата съемки: из формы, затем из EXIF, иначе время приема
+	dateTaken, dateSource := time.Now(), storage.SourceReceived
+	if parsed, err := time.Parse(time.RFC3339, meta.DateTaken); meta.DateTaken != "" && err == nil {
+		dateTaken, dateSource = parsed, storage.SourceForm
+	} else if !photoExif.DateTaken.IsZero() {
+		dateTaken, dateSource = photoExif.DateTaken, storage.SourceEXIF
+	}
 
-	// Вычисляем хеш файла
-	fileHash := h.fileManager.CalculateHash(data)
-	size := int64(len(data))
+	// Хеш и размер посчитаны при приеме потока
+	fileHash := incoming.Hash
+	size := incoming.Size
 
 	// Проверяем дубликаты
 	existingFile, reason := h.duplicateCheck.CheckDuplicate(fileHash, size, counterNumber, dateTaken, h.indexer)
 	isDuplicate := existingFile != nil
 
 	if isDuplicate {
+		h.fileManager.DiscardIncoming(incoming)
+
 		// Обновляем сессию
 		h.sessionStore.Update(token, func(session *models.Session) {
 			session.Skipped++
 			session.Status = models.StatusSyncing
 			session.CurrentFile = originalName
-		})
+			session.LastResult = &models.FileResult{
+				Name:          originalName,
+				Status:        models.FileDuplicate,
+				CounterNumber: counterNumber,
+				Hash:          fileHash,
+				Path:          existingFile.Path,
+				Reason:        reason,
+			}
+			session.Received = append(session.Received, *session.LastResult)
 
-		c.JSON(http.StatusOK, gin.H{
-			"success":      true,
-			"uploaded":     session.Uploaded,
-			"total":        session.Total,
-			"filepath":     existingFile.Path,
-			"isDuplicate":  true,
-			"reason":       reason,
-			"existingFile": existingFile.Path,
+			// Последний файл тоже может оказаться дубликатом
+			if session.Uploaded+session.Skipped >= session.Total {
+				session.Status = models.StatusCompleted
+			}
 		})
-		return
+
+		return &ingestResult{
+			RelPath:     existingFile.Path,
+			IsDuplicate: true,
+			Reason:      reason,
+		}, nil
+	}
+
+	// USER_COMMENT может содержать номер счетчика, показание и другие поля.
+	// Неверные поля пропускаются с предупреждением, остальные используются.
+	var warnings []string
+	comment, commentErrs := metadata.ParseComment(photoExif.UserComment)
+	for _, err := range commentErrs {
+		warnings = append(warnings, "USER_COMMENT: "+err.Error())
 	}
 
 	// Извлекаем номер счетчика из EXIF, если не передан
+	counterSource := storage.SourceForm
 	if counterNumber == "" {
-		counterNumber = extractCounterNumberFromEXIF(data)
+		counterNumber, counterSource = comment.CounterNumber, storage.SourceEXIF
 		if counterNumber == "" {
-			counterNumber = "unknown"
+			counterNumber, counterSource = "unknown", storage.SourceUnknown
 		}
 	}
 
-	// Извлекаем полный USER_COMMENT из EXIF для сохранения в индекс
-	userComment := extractUserCommentFromEXIF(data)
+	// Показания: из формы, иначе из USER_COMMENT. Неверное показание не мешает принять фото.
+	readings, readingSource := comment.Readings(), ""
+	if len(readings) > 0 {
+		readingSource = storage.SourceEXIF
+	}
+	formReadings, readingWarnings := parseFormReadings(meta)
+	warnings = append(warnings, readingWarnings...)
+	if len(formReadings) > 0 {
+		readings, readingSource = formReadings, storage.SourceForm
+	}
+
+	// Сверяем счетчик с реестром. Пока реестр пуст, он не используется
+	// и предупреждения не выдаются.
+	if _, known := h.meters.Get(counterNumber); !known && h.meters.Count() > 0 {
+		warnings = append(warnings, fmt.Sprintf("counter %s is not in the meter registry", counterNumber))
+	}
 
-	// Сохраняем файл
-	relPath, err := h.fileManager.SaveFile(originalName, data, dateTaken)
+	// Подозрительное показание отмечается, чтобы контролер перепроверил его на месте.
+	// Фото принимается в любом случае.
+	var anomalies []models.Anomaly
+	if len(readings) > 0 && counterSource != storage.SourceUnknown {
+		history := h.indexer.GetPhotosByCounter(counterNumber)
+		for _, register := range sortedKeys(readings) {
+			anomalies = append(anomalies, storage.DetectAnomalies(history, register, dateTaken, readings[register])...)
+		}
+	}
+
+	// Сохраняем файл атомарным переименованием
+	relPath, err := h.fileManager.CommitIncoming(incoming, originalName, counterNumber, dateTaken)
 	if err != nil {
-		h.sessionStore.Update(token, func(session *models.Session) {
-			session.Errors = append(session.Errors, err.Error())
-		})
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save file"})
-		return
+		h.fileManager.DiscardIncoming(incoming)
+		h.reportFileError(token, originalName, err)
+		return nil, err
 	}
 
 	fullPath := filepath.Join(h.fileManager.BaseDir(), relPath)
 
-	// Добавляем в индекс с USER_COMMENT
-	if err := h.indexer.AddPhoto(counterNumber, relPath, fullPath, dateTaken, size, fileHash, userComment); err != nil {
-		// Логируем ошибку, но не прерываем процесс
-		fmt.Printf("Warning: Failed to add photo to index: %v\n", err)
+	// Добавляем в индекс с USER_COMMENT и метаданными EXIF
+	photo := &storage.PhotoInfo{
+		Path:          relPath,
+		FullPath:      fullPath,
+		Date:          dateTaken,
+		Size:          size,
+		Hash:          fileHash,
+		UserComment:   photoExif.UserComment,
+		DateSource:    dateSource,
+		CounterSource: counterSource,
+
+		CommentVersion: comment.Version,
+		ReadingSource:  readingSource,
+		Apartment:      comment.Apartment,
+		InspectorID:    comment.InspectorID,
+		Notes:          comment.Notes,
+		Anomalies:      anomalies,
+
+		CameraMake:  photoExif.CameraMake,
+		CameraModel: photoExif.CameraModel,
+		Orientation: photoExif.Orientation,
+		Width:       photoExif.Width,
+		Height:      photoExif.Height,
+		GPS:         photoExif.GPS,
+	}
+	photo.SetReadings(readings)
+	if err := h.indexer.AddPhoto(counterNumber, photo); err != nil {
+		// Фото без записи в индексе не найти ни в галерее, ни по хешу: убираем файл,
+		// чтобы устройство отправило его повторно. Хеш в базу дубликатов еще не добавлен.
+		if removeErr := h.fileManager.RemoveFile(relPath); removeErr != nil {
+			fmt.Printf("Warning: Failed to remove %s after index error: %v\n", relPath, removeErr)
+		}
+		h.reportFileError(token, originalName, err)
+		return nil, err
+	}
+
+	// Новое показание меняет историю счетчика: отметки соседних по дате фото пересчитываются
+	if len(readings) > 0 && counterSource != storage.SourceUnknown {
+		if _, err := h.indexer.RecheckAnomalies(counterNumber, sortedKeys(readings)); err != nil {
+			fmt.Printf("Warning: Failed to recheck readings of counter %s: %v\n", counterNumber, err)
+		}
 	}
 
-	// Добавляем хеш в базу дубликатов
+	// Добавляем хеш в базу дубликатов только после записи в индекс
 	h.duplicateCheck.AddHash(fileHash, size, dateTaken, relPath)
 
+	// Миниатюры создаются в фоне, ответ устройству не ждет их
+	h.thumbnails.GenerateAsync(fileHash, fullPath)
+
 	// Обновляем сессию
 	h.sessionStore.Update(token, func(session *models.Session) {
 		session.Uploaded++
 		session.Status = models.StatusSyncing
 		session.CurrentFile = originalName
+		session.LastResult = &models.FileResult{
+			Name:          originalName,
+			Status:        models.FileAccepted,
+			CounterNumber: counterNumber,
+			Hash:          fileHash,
+			Path:          relPath,
+			Warnings:      warnings,
+			Anomalies:     anomalies,
+		}
+		session.Received = append(session.Received, *session.LastResult)
 
 		// Проверяем, завершена ли синхронизация
 		if session.Uploaded+session.Skipped >= session.Total {
@@ -220,12 +501,71 @@ func (h *Handlers) SyncHandler(c *gin.Context) {
 		}
 	})
 
-	c.JSON(http.StatusOK, gin.H{
-		"success":     true,
-		"uploaded":    session.Uploaded,
-		"total":       session.Total,
-		"filepath":    relPath,
-		"isDuplicate": false,
+	return &ingestResult{RelPath: relPath, Warnings: warnings, Anomalies: anomalies}, nil
+}
+
+// parseFormReadings разбирает показания из полей reading и reading.<регистр>.
+// Неверные показания и названия регистров пропускаются с предупреждением.
+func parseFormReadings(meta photoMeta) (map[string]float64, []string) {
+	fields := make(map[string]string, len(meta.Registers)+1)
+	for name, value := range meta.Registers {
+		fields[name] = value
+	}
+	if meta.Reading != "" {
+		fields[metadata.DefaultRegister] = meta.Reading
+	}
+
+	readings := make(map[string]float64)
+	var warnings []string
+	for _, name := range sortedKeys(fields) {
+		register, err := metadata.NormalizeRegister(name)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("reading register %q is invalid and was ignored", name))
+			continue
+		}
+		value, err := metadata.ParseReading(fields[name])
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("reading %q is not a number and was ignored", fields[name]))
+			continue
+		}
+		readings[register] = value
+	}
+	return readings, warnings
+}
+
+// registerFields выбирает показания регистров из ключей reading.<регистр> Upload-Metadata
+func registerFields(fields map[string]string) map[string]string {
+	var registers map[string]string
+	for key, value := range fields {
+		if register, ok := metadata.RegisterField(key); ok {
+			if registers == nil {
+				registers = make(map[string]string)
+			}
+			registers[register] = value
+		}
+	}
+	return registers
+}
+
+// sortedKeys возвращает ключи по алфавиту: поля формы, регистры показаний
+func sortedKeys[V any](fields map[string]V) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// reportFileError записывает ошибку обработки файла в сессию
+func (h *Handlers) reportFileError(token, originalName string, err error) {
+	h.sessionStore.Update(token, func(session *models.Session) {
+		session.Errors = append(session.Errors, err.Error())
+		session.LastResult = &models.FileResult{
+			Name:   originalName,
+			Status: models.FileError,
+			Error:  err.Error(),
+		}
 	})
 }
 
@@ -243,7 +583,12 @@ func (h *Handlers) StatusHandler(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	c.JSON(http.StatusOK, statusFields(session))
+}
+
+// statusFields возвращает поля прогресса сессии для /status и потока событий
+func statusFields(session *models.Session) gin.H {
+	return gin.H{
 		"status":                 string(session.Status),
 		"total":                  session.Total,
 		"uploaded":               session.Uploaded,
@@ -252,7 +597,7 @@ func (h *Handlers) StatusHandler(c *gin.Context) {
 		"currentFile":            session.CurrentFile,
 		"startTime":              session.StartTime.Format(time.RFC3339),
 		"estimatedTimeRemaining": session.GetEstimatedTimeRemaining(),
-	})
+	}
 }
 
 // IndexHandler возвращает индекс фото по номеру счетчика
@@ -264,19 +609,42 @@ func (h *Handlers) IndexHandler(c *gin.Context) {
 		counters := h.indexer.GetAllCounters()
 		result := make(map[string]interface{})
 		for _, counter := range counters {
-			photos := h.indexer.GetPhotosByCounter(counter)
-			result[counter] = photos
+			result[counter] = h.indexPhotos(counter, h.indexer.GetPhotosByCounter(counter))
 		}
 		c.JSON(http.StatusOK, result)
 		return
 	}
 
 	photos := h.indexer.GetPhotosByCounter(counterNumber)
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"counterNumber": counterNumber,
-		"photos":        photos,
+		"photos":        h.indexPhotos(counterNumber, photos),
 		"total":         len(photos),
-	})
+	}
+	if meter, known := h.meters.Get(counterNumber); known {
+		response["meter"] = meter
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// IndexPhoto - фото индекса с номером счетчика в реестре
+type IndexPhoto struct {
+	*storage.PhotoInfo
+	MeterSerial string `json:"meterSerial,omitempty"` // номер из реестра на момент запроса, если счетчик в нем есть
+}
+
+// indexPhotos дополняет фото счетчика номером из реестра. Номер не хранится в индексе,
+// чтобы правка или удаление счетчика в реестре сразу отражались в ответе.
+func (h *Handlers) indexPhotos(counterNumber string, photos []*storage.PhotoInfo) []IndexPhoto {
+	meterSerial := ""
+	if meter, known := h.meters.Get(counterNumber); known {
+		meterSerial = meter.SerialNumber
+	}
+	result := make([]IndexPhoto, len(photos))
+	for i, photo := range photos {
+		result[i] = IndexPhoto{PhotoInfo: photo, MeterSerial: meterSerial}
+	}
+	return result
 }
 
 // DeleteSessionHandler удаляет сессию
@@ -291,219 +659,18 @@ func (h *Handlers) DeleteSessionHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
-// extractUserCommentFromEXIF извлекает полный USER_COMMENT из EXIF метаданных
-func extractUserCommentFromEXIF(data []byte) string {
-	// Используем библиотеку goexif для правильного парсинга EXIF
-	reader := bytes.NewReader(data)
-	x, err := exif.Decode(reader)
-	if err != nil {
-		// Fallback к упрощенному поиску
-		return findUserCommentFallback(data)
-	}
-
-	// Ищем USER_COMMENT тег (0x9286)
-	userCommentTag, err := x.Get(exif.UserComment)
-	if err != nil {
-		// Fallback к упрощенному поиску
-		return findUserCommentFallback(data)
-	}
+// exifHeadSize - сколько байт из начала файла читается для разбора EXIF.
+// Сегмент APP1 ограничен 64 КБ и идет сразу после SOI (иногда после APP0).
+const exifHeadSize = 128 * 1024
 
-	// Получаем значение тега
-	userComment, err := userCommentTag.StringVal()
+// readEXIFHead читает начало принятого файла, в котором находятся EXIF метаданные
+func readEXIFHead(fm *storage.FileManager, incoming *storage.IncomingFile) []byte {
+	file, err := fm.OpenIncoming(incoming)
 	if err != nil {
-		// Fallback к упрощенному поиску
-		return findUserCommentFallback(data)
-	}
-
-	// Удаляем префикс кодировки, если он есть (ASCII\0\0\0 или UNICODE\0\0\0)
-	// Согласно стандарту EXIF, UserComment может иметь префикс кодировки
-	userComment = cleanUserComment(userComment)
-
-	return userComment
-}
-
-// extractCounterNumberFromEXIF извлекает номер счетчика из EXIF метаданных USER_COMMENT
-func extractCounterNumberFromEXIF(data []byte) string {
-	// Используем extractUserCommentFromEXIF для получения полного комментария
-	userComment := extractUserCommentFromEXIF(data)
-	if userComment != "" {
-		return userComment
-	}
-	// Если не нашли через goexif, пробуем fallback метод
-	return findUserCommentFallback(data)
-}
-
-// findUserCommentFallback ищет USER_COMMENT в EXIF данных (fallback реализация)
-// Используется, если goexif не смог распарсить EXIF
-func findUserCommentFallback(data []byte) string {
-
-	// Проверяем JPEG маркер
-	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
-		return ""
-	}
-
-	offset := 2
-	for offset < len(data)-1 {
-		// Ищем маркер сегмента
-		if data[offset] != 0xFF {
-			break
-		}
-
-		marker := data[offset+1]
-		offset += 2
-
-		// Пропускаем маркеры без данных
-		if marker == 0xFF {
-			continue
-		}
-
-		// APP1 сегмент содержит EXIF данные
-		if marker == 0xE1 {
-			if offset+2 > len(data) {
-				break
-			}
-			length := int(binary.BigEndian.Uint16(data[offset : offset+2]))
-			if length < 2 || offset+length > len(data) {
-				break
-			}
-
-			// Проверяем "Exif\0\0" заголовок
-			if offset+6 <= len(data) && string(data[offset+2:offset+8]) == "Exif\x00\x00" {
-				// Ищем USER_COMMENT в EXIF данных
-				comment := findUserCommentInRawData(data[offset+2 : offset+length])
-				if comment != "" {
-					return comment
-				}
-			}
-
-			offset += length
-			continue
-		}
-
-		// Читаем длину сегмента для других маркеров
-		if offset+2 > len(data) {
-			break
-		}
-		length := int(binary.BigEndian.Uint16(data[offset : offset+2]))
-		if length < 2 {
-			break
-		}
-		offset += length
-	}
-
-	return ""
-}
-
-// findUserCommentInRawData ищет номер счетчика в сырых EXIF данных
-// Ищет номера длиной от 3 символов (вместо 8-10)
-func findUserCommentInRawData(exifData []byte) string {
-	// Ищем в виде строки в EXIF данных
-	dataStr := string(exifData)
-
-	// Ищем последовательности букв и цифр длиной от 3 символов
-	// Сначала ищем более длинные последовательности (от 10 символов)
-	for i := 0; i < len(dataStr)-10; i++ {
-		if isAlphanumeric(dataStr[i]) {
-			j := i
-			for j < len(dataStr) && (isAlphanumeric(dataStr[j]) || isCyrillic(dataStr[j])) {
-				j++
-			}
-			if j-i >= 10 {
-				candidate := dataStr[i:j]
-				// Проверяем, что это похоже на номер счетчика (содержит цифры)
-				if containsDigit(candidate) {
-					return candidate
-				}
-			}
-			i = j
-		}
-	}
-
-	// Ищем средние последовательности (от 5 до 9 символов)
-	for i := 0; i < len(dataStr)-5; i++ {
-		if isAlphanumeric(dataStr[i]) {
-			j := i
-			for j < len(dataStr) && (isAlphanumeric(dataStr[j]) || isCyrillic(dataStr[j])) {
-				j++
-			}
-			if j-i >= 5 && j-i < 10 {
-				candidate := dataStr[i:j]
-				// Проверяем, что это похоже на номер счетчика (содержит цифры и только буквы/цифры)
-				if containsDigit(candidate) && isOnlyAlphanumeric(candidate) {
-					return candidate
-				}
-			}
-			i = j
-		}
-	}
-
-	// Ищем короткие последовательности (от 3 до 4 символов) - для коротких номеров счетчиков
-	for i := 0; i < len(dataStr)-3; i++ {
-		if isAlphanumeric(dataStr[i]) {
-			j := i
-			for j < len(dataStr) && (isAlphanumeric(dataStr[j]) || isCyrillic(dataStr[j])) {
-				j++
-			}
-			if j-i >= 3 && j-i < 5 {
-				candidate := dataStr[i:j]
-				// Проверяем, что это похоже на номер счетчика (содержит цифры и только буквы/цифры)
-				if containsDigit(candidate) && isOnlyAlphanumeric(candidate) {
-					return candidate
-				}
-			}
-			i = j
-		}
-	}
-
-	return ""
-}
-
-func isAlphanumeric(b byte) bool {
-	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9')
-}
-
-func isCyrillic(b byte) bool {
-	// Проверяем кириллицу (упрощенно)
-	return b >= 0xD0 && b <= 0xDF || b >= 0xE0 && b <= 0xEF
-}
-
-func containsDigit(s string) bool {
-	for _, r := range s {
-		if r >= '0' && r <= '9' {
-			return true
-		}
-	}
-	return false
-}
-
-func isOnlyAlphanumeric(s string) bool {
-	for _, r := range s {
-		if !((r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')) {
-			return false
-		}
-	}
-	return true
-}
-
-// cleanUserComment удаляет префикс кодировки из UserComment
-// Согласно стандарту EXIF, UserComment может иметь префикс "ASCII\0\0\0" или "UNICODE\0\0\0"
-func cleanUserComment(comment string) string {
-	if len(comment) == 0 {
-		return comment
-	}
-
-	// Проверяем префикс "ASCII\0\0\0" (8 символов)
-	if len(comment) > 8 && comment[:5] == "ASCII" {
-		// Пропускаем "ASCII" и следующие 3 нулевых байта
-		return comment[8:]
-	}
-
-	// Проверяем префикс "UNICODE\0\0\0" (10 символов)
-	if len(comment) > 10 && comment[:7] == "UNICODE" {
-		// Пропускаем "UNICODE" и следующие 3 нулевых байта
-		return comment[10:]
+		return nil
 	}
+	defer file.Close()
 
-	// Если префикса нет, возвращаем как есть
-	return comment
+	data, _ := io.ReadAll(io.LimitReader(file, exifHeadSize))
+	return data
 }
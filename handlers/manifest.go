@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"photo-sync-server/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ManifestEntry описывает одно фото, которое устройство собирается загрузить
+type ManifestEntry struct {
+	Hash          string `json:"hash"`
+	Size          int64  `json:"size"`
+	CounterNumber string `json:"counterNumber,omitempty"`
+	DateTaken     string `json:"dateTaken,omitempty"`
+	OriginalName  string `json:"originalName,omitempty"`
+}
+
+// ManifestResult содержит решение сервера по одной записи манифеста
+type ManifestResult struct {
+	Index        int    `json:"index"`
+	Hash         string `json:"hash"`
+	OriginalName string `json:"originalName,omitempty"`
+	Needed       bool   `json:"needed"`
+	Reason       string `json:"reason,omitempty"`
+	ExistingFile string `json:"existingFile,omitempty"`
+}
+
+// ManifestHandler принимает список фото устройства и возвращает те, которых нет на сервере.
+// Манифест описывает весь пакет синхронизации: Total и Skipped сессии заполняются сразу,
+// чтобы /status показывал верный прогресс еще до загрузки первого файла.
+func (h *Handlers) ManifestHandler(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+		return
+	}
+
+	if _, exists := h.sessionStore.Get(token); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	var req struct {
+		Entries []ManifestEntry `json:"entries"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	results := make([]ManifestResult, 0, len(req.Entries))
+	needed := make([]string, 0, len(req.Entries))
+	seen := make(map[string]bool)
+	skipped := 0
+
+	for i, entry := range req.Entries {
+		result := h.checkManifestEntry(entry, seen)
+		result.Index = i
+		if result.Needed {
+			needed = append(needed, result.Hash)
+		} else {
+			skipped++
+		}
+		if result.Hash != "" {
+			seen[result.Hash] = true
+		}
+		results = append(results, result)
+	}
+
+	total := len(req.Entries)
+	h.sessionStore.Update(token, func(session *models.Session) {
+		// Повторный манифест (например, после обрыва связи) не сбрасывает прогресс:
+		// файлы, уже принятые в этой сессии, остаются загруженными, а не пропущенными.
+		// Каждый принятый файл закрывает одну запись манифеста, копии того же хеша
+		// в манифесте считаются пропущенными
+		accepted := make(map[string]int)
+		for _, received := range session.Received {
+			if received.Status == models.FileAccepted && received.Hash != "" {
+				accepted[received.Hash]++
+			}
+		}
+		sessionSkipped := 0
+		for _, result := range results {
+			if result.Needed {
+				continue
+			}
+			if accepted[result.Hash] > 0 {
+				accepted[result.Hash]--
+				continue
+			}
+			sessionSkipped++
+		}
+		// Загруженные вне манифеста файлы не должны раздувать прогресс выше Total
+		if sessionSkipped > total-session.Uploaded {
+			sessionSkipped = max(total-session.Uploaded, 0)
+		}
+
+		if session.Total == 0 && session.Uploaded == 0 {
+			session.StartTime = time.Now()
+		}
+		session.Total = total
+		session.Skipped = sessionSkipped
+		session.Status = models.StatusReady
+		if session.Uploaded > 0 {
+			session.Status = models.StatusSyncing
+		}
+		if session.Uploaded+session.Skipped >= total {
+			session.Status = models.StatusCompleted
+		}
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"total":   total,
+		"needed":  needed,
+		"skipped": skipped,
+		"entries": results,
+	})
+}
+
+// checkManifestEntry проверяет одну запись манифеста по базе дубликатов и индексу
+func (h *Handlers) checkManifestEntry(entry ManifestEntry, seen map[string]bool) ManifestResult {
+	hash := strings.ToLower(strings.TrimSpace(entry.Hash))
+	result := ManifestResult{
+		Hash:         hash,
+		OriginalName: entry.OriginalName,
+		Needed:       true,
+	}
+
+	// Без хеша проверить нечего - файл нужно загрузить
+	if hash == "" {
+		return result
+	}
+
+	// Одинаковые файлы внутри одного манифеста загружаются один раз
+	if seen[hash] {
+		result.Needed = false
+		result.Reason = "manifest"
+		return result
+	}
+
+	var dateTaken time.Time
+	if entry.DateTaken != "" {
+		if parsed, err := time.Parse(time.RFC3339, entry.DateTaken); err == nil {
+			dateTaken = parsed
+		}
+	}
+
+	if existing, reason := h.duplicateCheck.CheckDuplicate(hash, entry.Size, entry.CounterNumber, dateTaken, h.indexer); existing != nil {
+		result.Needed = false
+		result.Reason = reason
+		result.ExistingFile = existing.Path
+		return result
+	}
+
+	// Запись индекса без файла на диске (файл удален или перемещен вручную) не мешает загрузке
+	if photo := h.indexer.FindByHash(hash); photo != nil && h.fileManager.FileExists(photo.Path) {
+		result.Needed = false
+		result.Reason = "index"
+		result.ExistingFile = photo.Path
+	}
+
+	return result
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"photo-sync-server/models"
+	"photo-sync-server/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxMetersCSVSize ограничивает размер импортируемого CSV реестра
+const maxMetersCSVSize = 16 << 20
+
+// MeterInfo - счетчик реестра со сведениями о его фото
+type MeterInfo struct {
+	*models.Meter
+	Photos    int    `json:"photos"`
+	LastPhoto string `json:"lastPhoto,omitempty"` // относительный путь последнего фото
+}
+
+// meterInfo дополняет счетчик реестра данными из индекса фото
+func (h *Handlers) meterInfo(meter *models.Meter) MeterInfo {
+	return newMeterInfo(meter, h.indexer.GetCounterStats(meter.SerialNumber))
+}
+
+// newMeterInfo собирает MeterInfo из сводки индекса по счетчику
+func newMeterInfo(meter *models.Meter, stats storage.CounterStats) MeterInfo {
+	info := MeterInfo{Meter: meter, Photos: stats.Photos}
+	if stats.LastPhoto != nil {
+		info.LastPhoto = stats.LastPhoto.Path
+	}
+	return info
+}
+
+// ListMetersHandler возвращает реестр счетчиков. Параметр q фильтрует по номеру, адресу и лицевому счету.
+func (h *Handlers) ListMetersHandler(c *gin.Context) {
+	query := strings.ToLower(strings.TrimSpace(c.Query("q")))
+	meterType := models.MeterType(c.Query("type"))
+
+	// Сводка по всем счетчикам за один проход индекса, а не запрос на каждый счетчик
+	stats := h.indexer.AllCounterStats()
+	result := make([]MeterInfo, 0)
+	for _, meter := range h.meters.List() {
+		if meterType != "" && meter.Type != meterType {
+			continue
+		}
+		if query != "" && !strings.Contains(strings.ToLower(meter.SerialNumber+" "+meter.Address+" "+meter.Account), query) {
+			continue
+		}
+		result = append(result, newMeterInfo(meter, stats[storage.NormalizeCounterNumber(meter.SerialNumber)]))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"meters": result,
+		"total":  len(result),
+	})
+}
+
+// GetMeterHandler возвращает счетчик по номеру в любом написании
+func (h *Handlers) GetMeterHandler(c *gin.Context) {
+	meter, exists := h.meters.Get(c.Param("serial"))
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "meter not found"})
+		return
+	}
+	c.JSON(http.StatusOK, h.meterInfo(meter))
+}
+
+// CreateMeterHandler добавляет счетчик в реестр
+func (h *Handlers) CreateMeterHandler(c *gin.Context) {
+	var meter models.Meter
+	if err := c.ShouldBindJSON(&meter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid meter"})
+		return
+	}
+
+	if err := h.meters.Create(&meter); err != nil {
+		h.meterError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, h.meterInfo(&meter))
+}
+
+// UpdateMeterHandler заменяет поля счетчика. Если serialNumber в теле не указан,
+// номер остается прежним.
+func (h *Handlers) UpdateMeterHandler(c *gin.Context) {
+	serial := c.Param("serial")
+	existing, exists := h.meters.Get(serial)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "meter not found"})
+		return
+	}
+
+	var meter models.Meter
+	if err := c.ShouldBindJSON(&meter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid meter"})
+		return
+	}
+	if strings.TrimSpace(meter.SerialNumber) == "" {
+		meter.SerialNumber = existing.SerialNumber
+	}
+
+	if err := h.meters.Update(serial, &meter); err != nil {
+		h.meterError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, h.meterInfo(&meter))
+}
+
+// DeleteMeterHandler удаляет счетчик из реестра (фото счетчика остаются)
+func (h *Handlers) DeleteMeterHandler(c *gin.Context) {
+	if err := h.meters.Delete(c.Param("serial")); err != nil {
+		h.meterError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// ImportMetersHandler добавляет и обновляет счетчики из CSV.
+// CSV передается телом запроса или полем file multipart формы. Строки с ошибками
+// пропускаются и перечисляются в ответе, остальные импортируются одной транзакцией.
+func (h *Handlers) ImportMetersHandler(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxMetersCSVSize)
+
+	var source io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		file, _, err := c.Request.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "CSV file is required"})
+			return
+		}
+		defer file.Close()
+		source = file
+	}
+
+	meters, rowErrors, err := storage.ParseMetersCSV(source)
+	if err != nil {
+		if isRequestTooLarge(err) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "CSV file is too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	created, updated, err := h.meters.Import(meters)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to import meters"})
+		return
+	}
+
+	if rowErrors == nil {
+		rowErrors = []storage.MeterCSVError{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"created": created,
+		"updated": updated,
+		"errors":  rowErrors,
+		"total":   h.meters.Count(),
+	})
+}
+
+// meterError отвечает на ошибку изменения реестра
+func (h *Handlers) meterError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, storage.ErrMeterNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "meter not found"})
+	case errors.Is(err, storage.ErrMeterExists):
+		c.JSON(http.StatusConflict, gin.H{"error": "meter already exists"})
+	case errors.Is(err, storage.ErrInvalidMeter):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save meter"})
+	}
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"photo-sync-server/security"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+)
+
+// Размер PNG с QR кодом в пикселях
+const (
+	defaultQRSize = 320
+	minQRSize     = 128
+	maxQRSize     = 1024
+)
+
+// Pairing содержит данные для сопряжения устройства с сервером.
+// Именно они кодируются в QR код, который сканирует приложение.
+// URLs содержит адрес синхронизации для каждого сетевого интерфейса ПК:
+// приложение перебирает их, пока не найдет доступный.
+type Pairing struct {
+	URL             string   `json:"url"`
+	URLs            []string `json:"urls,omitempty"`
+	Token           string   `json:"token"`
+	Secret          string   `json:"secret"`
+	CertFingerprint string   `json:"certFingerprint,omitempty"`
+}
+
+// CreatePairing создает новую сессию и возвращает данные для ее сопряжения
+func (h *Handlers) CreatePairing() (*Pairing, error) {
+	token := generateShortToken()
+
+	// Секрет сессии передается устройству при сопряжении и подписывает все его запросы
+	secret, err := security.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	_ = h.sessionStore.Create(token, secret)
+
+	return h.pairingFor(token, secret), nil
+}
+
+// pairingFor собирает данные сопряжения для существующей сессии
+func (h *Handlers) pairingFor(token, secret string) *Pairing {
+	pairing := &Pairing{
+		URL:             h.syncURL(h.primaryAddress(), token),
+		Token:           token,
+		Secret:          secret,
+		CertFingerprint: h.tlsFingerprint,
+	}
+	for _, address := range h.addresses {
+		pairing.URLs = append(pairing.URLs, h.syncURL(address, token))
+	}
+	return pairing
+}
+
+// primaryAddress возвращает основной адрес сервера в локальной сети
+func (h *Handlers) primaryAddress() string {
+	if len(h.addresses) == 0 {
+		return "localhost"
+	}
+	return h.addresses[0]
+}
+
+// syncURL собирает адрес синхронизации для указанного адреса сервера
+func (h *Handlers) syncURL(address, token string) string {
+	host := net.JoinHostPort(address, strconv.Itoa(h.port))
+	return fmt.Sprintf("%s://%s/sync?token=%s", h.config.Scheme(), host, token)
+}
+
+// Payload возвращает содержимое QR кода (JSON)
+func (p *Pairing) Payload() string {
+	data, _ := json.Marshal(p)
+	return string(data)
+}
+
+// TerminalQR возвращает QR код из символов блоков для вывода в консоль
+func (p *Pairing) TerminalQR() (string, error) {
+	code, err := qrcode.New(p.Payload(), qrcode.Medium)
+	if err != nil {
+		return "", err
+	}
+	// Консоль обычно темная: светлые модули рисуются блоками, темные остаются фоном
+	return code.ToSmallString(false), nil
+}
+
+// StartQRHandler отдает QR код сопряжения в формате PNG или SVG.
+// С параметром token кодируется существующая сессия, без него создается новая;
+// токен новой сессии возвращается в заголовке X-Sync-Token.
+func (h *Handlers) StartQRHandler(format string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var pairing *Pairing
+		if token := c.Query("token"); token != "" {
+			session, exists := h.sessionStore.Get(token)
+			if !exists {
+				c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+				return
+			}
+			pairing = h.pairingFor(token, session.Secret)
+		} else {
+			created, err := h.CreatePairing()
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create session"})
+				return
+			}
+			pairing = created
+		}
+
+		code, err := qrcode.New(pairing.Payload(), qrcode.Medium)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate QR code"})
+			return
+		}
+
+		// QR содержит секрет сессии, поэтому его нельзя кешировать
+		c.Header("Cache-Control", "no-store")
+		c.Header("X-Sync-Token", pairing.Token)
+
+		if format == "svg" {
+			c.Data(http.StatusOK, "image/svg+xml", []byte(qrSVG(code)))
+			return
+		}
+
+		size := defaultQRSize
+		if value := c.Query("size"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < minQRSize || parsed > maxQRSize {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("size must be between %d and %d", minQRSize, maxQRSize)})
+				return
+			}
+			size = parsed
+		}
+
+		png, err := code.PNG(size)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate QR code"})
+			return
+		}
+		c.Data(http.StatusOK, "image/png", png)
+	}
+}
+
+// qrSVG рисует QR код в SVG: один квадрат на модуль, масштабируется через viewBox
+func qrSVG(code *qrcode.QRCode) string {
+	bitmap := code.Bitmap()
+	size := len(bitmap)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, size, size)
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return b.String()
+}
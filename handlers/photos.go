@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"photo-sync-server/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PhotoHandler отдает оригинал фото по SHA256 хешу
+func (h *Handlers) PhotoHandler(c *gin.Context) {
+	hash := strings.ToLower(c.Param("hash"))
+	if !isSHA256(hash) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid photo hash"})
+		return
+	}
+
+	photo := h.indexer.FindByHash(hash)
+	if photo == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "photo not found"})
+		return
+	}
+
+	fullPath, _, err := h.fileManager.ResolvePath(filepath.ToSlash(photo.Path))
+	if err != nil {
+		h.photoError(c, err)
+		return
+	}
+	servePhoto(c, fullPath, photo)
+}
+
+// PhotoByPathHandler отдает оригинал фото по относительному пути в папке с фото
+func (h *Handlers) PhotoByPathHandler(c *gin.Context) {
+	fullPath, relPath, err := h.fileManager.ResolvePath(c.Param("relPath"))
+	if err != nil {
+		h.photoError(c, err)
+		return
+	}
+	servePhoto(c, fullPath, h.indexer.FindByPath(relPath))
+}
+
+// photoError отвечает на ошибку поиска файла фото
+func (h *Handlers) photoError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, storage.ErrInvalidPath):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid photo path"})
+	case errors.Is(err, os.ErrNotExist):
+		c.JSON(http.StatusNotFound, gin.H{"error": "photo file is missing"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to open photo"})
+	}
+}
+
+// servePhoto отдает файл с поддержкой Range, If-None-Match и If-Modified-Since.
+// ETag - хеш содержимого из индекса; если фото нет в индексе или файл изменился
+// после индексации (не совпал размер), ETag не выставляется.
+func servePhoto(c *gin.Context, fullPath string, photo *storage.PhotoInfo) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to open photo"})
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to open photo"})
+		return
+	}
+
+	if photo != nil && photo.Hash != "" && photo.Size == info.Size() {
+		c.Header("ETag", `"`+photo.Hash+`"`)
+	}
+	c.Header("Cache-Control", "private, no-cache")
+
+	// ServeContent определяет Content-Type по расширению (или содержимому)
+	// и сам обрабатывает Range и условные заголовки
+	http.ServeContent(c.Writer, c.Request, filepath.Base(fullPath), info.ModTime(), file)
+}
+
+// isSHA256 проверяет, что строка - hex запись SHA256
+func isSHA256(value string) bool {
+	if len(value) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(value)
+	return err == nil
+}
+
+// ThumbnailHandler отдает JPEG миниатюру фото по его хешу.
+// Размер задается параметром size (длинная сторона в пикселях) и округляется вверх
+// до ближайшего из storage.ThumbnailSizes. Если миниатюры еще нет, она создается сразу.
+func (h *Handlers) ThumbnailHandler(c *gin.Context) {
+	size := storage.ThumbnailSizes[0]
+	if value := c.Query("size"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "size must be a positive number"})
+			return
+		}
+		size = storage.FitSize(parsed)
+	}
+
+	hash := strings.ToLower(c.Param("hash"))
+	if !isSHA256(hash) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid photo hash"})
+		return
+	}
+
+	photo := h.indexer.FindByHash(hash)
+	if photo == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "photo not found"})
+		return
+	}
+
+	thumbPath, err := h.thumbnails.Get(photo.Hash, size, filepath.Join(h.fileManager.BaseDir(), photo.Path))
+	if err != nil {
+		if errors.Is(err, storage.ErrUnsupportedImage) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "thumbnail is not available for this file"})
+			return
+		}
+		if errors.Is(err, os.ErrNotExist) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "photo file is missing"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate thumbnail"})
+		return
+	}
+
+	// Миниатюра определяется содержимым фото (хешем), поэтому не меняется
+	c.Header("Cache-Control", "private, max-age=31536000, immutable")
+	c.File(thumbPath)
+}
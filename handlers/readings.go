@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"photo-sync-server/export"
+	"photo-sync-server/metadata"
+	"photo-sync-server/models"
+	"photo-sync-server/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AnomalyInfo - фото с подозрительным показанием
+type AnomalyInfo struct {
+	CounterNumber string             `json:"counterNumber"`
+	Path          string             `json:"path"`
+	Hash          string             `json:"hash"`
+	Date          time.Time          `json:"date"`
+	Reading       *float64           `json:"reading,omitempty"`
+	Registers     map[string]float64 `json:"registers,omitempty"`
+	Anomalies     []models.Anomaly   `json:"anomalies"`
+}
+
+// CounterReadingsHandler возвращает показания счетчика по каждому регистру по возрастанию
+// даты с расходом между соседними показаниями и итогами по месяцам.
+// Параметр register оставляет один регистр.
+func (h *Handlers) CounterReadingsHandler(c *gin.Context) {
+	counterNumber := c.Param("id")
+	photos := h.indexer.GetPhotosByCounter(counterNumber)
+	meter, known := h.meters.Get(counterNumber)
+	if len(photos) == 0 && !known {
+		c.JSON(http.StatusNotFound, gin.H{"error": "counter not found"})
+		return
+	}
+
+	history := storage.ReadingHistory(photos)
+	if name := c.Query("register"); name != "" {
+		register, err := metadata.NormalizeRegister(name)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		selected := make([]storage.RegisterHistory, 0, 1)
+		for _, registerHistory := range history {
+			if registerHistory.Register == register {
+				selected = append(selected, registerHistory)
+			}
+		}
+		history = selected
+	}
+
+	response := gin.H{
+		"counterNumber": counterNumber,
+		"registers":     history,
+	}
+	if known {
+		response["meter"] = meter
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// AnomaliesHandler возвращает фото с подозрительными показаниями, новые первыми.
+// Параметры counterNumber и type ограничивают выборку счетчиком и видом отметки,
+// from и to - периодом съемки в формате выгрузки.
+func (h *Handlers) AnomaliesHandler(c *gin.Context) {
+	counterFilter := storage.NormalizeCounterNumber(c.Query("counterNumber"))
+	typeFilter := models.AnomalyType(c.Query("type"))
+	period, err := export.ParseFilter("", c.Query("from"), c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := make([]AnomalyInfo, 0)
+	collect := func(counterNumber string, photo *storage.PhotoInfo) {
+		if len(photo.Anomalies) == 0 || !period.Match(counterNumber, photo) {
+			return
+		}
+		anomalies := photo.Anomalies
+		if typeFilter != "" {
+			anomalies = nil
+			for _, anomaly := range photo.Anomalies {
+				if anomaly.Type == typeFilter {
+					anomalies = append(anomalies, anomaly)
+				}
+			}
+			if len(anomalies) == 0 {
+				return
+			}
+		}
+		result = append(result, AnomalyInfo{
+			CounterNumber: counterNumber,
+			Path:          photo.Path,
+			Hash:          photo.Hash,
+			Date:          photo.Date,
+			Reading:       photo.Reading,
+			Registers:     photo.Registers,
+			Anomalies:     anomalies,
+		})
+	}
+
+	// Счетчик читается по индексу by_counter, без счетчика - фото периода по индексу by_date
+	if counterFilter != "" {
+		for _, photo := range h.indexer.GetPhotosByCounter(counterFilter) {
+			collect(counterFilter, photo)
+		}
+	} else {
+		h.indexer.ForEachPhotoInRange(period.From, period.To, collect)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Date.After(result[j].Date)
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"anomalies": result,
+		"total":     len(result),
+	})
+}
@@ -1,25 +1,73 @@
 package handlers
 
 import (
+	"net/http"
+
 	"github.com/gin-gonic/gin"
+	"photo-sync-server/config"
 	"photo-sync-server/storage"
+	"photo-sync-server/web"
 )
 
-// SetupRoutes настраивает маршруты API
-func SetupRoutes(router *gin.Engine, sessionStore *storage.SessionStore, fileManager *storage.FileManager, indexer *storage.Indexer, localIP string, port int) {
-	duplicateCheck := storage.NewDuplicateCheck()
+// SetupRoutes настраивает маршруты API и возвращает обработчики
+func SetupRoutes(router *gin.Engine, cfg *config.Config, sessionStore *storage.SessionStore, fileManager *storage.FileManager, indexer *storage.Indexer, duplicateCheck *storage.DuplicateCheck, uploadStore *storage.UploadStore, thumbnails *storage.ThumbnailCache, meters *storage.MeterRegistry, addresses []string, tlsFingerprint string) *Handlers {
+	handlers := NewHandlers(cfg, sessionStore, fileManager, indexer, duplicateCheck, uploadStore, thumbnails, meters, addresses, tlsFingerprint)
 
-	handlers := NewHandlers(sessionStore, fileManager, indexer, duplicateCheck, localIP, port)
+	admin := handlers.RequireAdmin()
+	signed := handlers.RequireSignature(false)
+	signedStream := handlers.RequireSignature(true)
+	signedForm := handlers.RequireFormSignature(true)
+	signedMetadata := handlers.RequireFormSignature(false)
 
 	// API endpoints
 	api := router.Group("/")
 	{
-		api.GET("/start", handlers.StartHandler)
-		api.POST("/init", handlers.InitHandler)
-		api.POST("/sync", handlers.SyncHandler)
+		// Управление: только администратор
+		api.POST("/admin/login", admin, handlers.AdminLoginHandler)
+		api.POST("/admin/logout", handlers.AdminLogoutHandler)
+		api.GET("/start", admin, handlers.StartHandler)
+		api.GET("/start/qr.png", admin, handlers.StartQRHandler("png"))
+		api.GET("/start/qr.svg", admin, handlers.StartQRHandler("svg"))
+		api.GET("/index", admin, handlers.IndexHandler)
+		api.GET("/index/counters", admin, handlers.CountersHandler)
+		api.GET("/counters/:id/readings", admin, handlers.CounterReadingsHandler)
+		api.GET("/anomalies", admin, handlers.AnomaliesHandler)
+		api.GET("/export.csv", admin, handlers.ExportCSVHandler)
+		api.GET("/export.xlsx", admin, handlers.ExportXLSXHandler)
+		api.GET("/export.zip", admin, handlers.ExportZIPHandler)
+		api.GET("/photos/by-path/*relPath", admin, handlers.PhotoByPathHandler)
+		api.HEAD("/photos/by-path/*relPath", admin, handlers.PhotoByPathHandler)
+		api.GET("/photos/:hash", admin, handlers.PhotoHandler)
+		api.HEAD("/photos/:hash", admin, handlers.PhotoHandler)
+		api.GET("/photos/:hash/thumb", admin, handlers.ThumbnailHandler)
+		api.DELETE("/session", admin, handlers.DeleteSessionHandler)
+		api.GET("/meters", admin, handlers.ListMetersHandler)
+		api.POST("/meters", admin, handlers.CreateMeterHandler)
+		api.POST("/meters/import", admin, handlers.ImportMetersHandler)
+		api.GET("/meters/:serial", admin, handlers.GetMeterHandler)
+		api.PUT("/meters/:serial", admin, handlers.UpdateMeterHandler)
+		api.DELETE("/meters/:serial", admin, handlers.DeleteMeterHandler)
+
+		// Запросы устройства: подписаны секретом сессии
+		api.POST("/init", signed, handlers.InitHandler)
+		api.POST("/manifest", signed, handlers.ManifestHandler)
+		api.POST("/sync", signedForm, handlers.SyncHandler)
+		api.POST("/uploads", signedMetadata, handlers.CreateUploadHandler)
+		api.HEAD("/uploads/:id", signed, handlers.HeadUploadHandler)
+		api.PATCH("/uploads/:id", signedStream, handlers.PatchUploadHandler)
+		api.DELETE("/uploads/:id", signed, handlers.DeleteUploadHandler)
+
+		// Прогресс для браузера
 		api.GET("/status", handlers.StatusHandler)
-		api.GET("/index", handlers.IndexHandler)
-		api.DELETE("/session", handlers.DeleteSessionHandler)
+		api.GET("/status/stream", handlers.StatusStreamHandler)
+		api.GET("/status/ws", handlers.StatusWebSocketHandler)
 	}
-}
 
+	// Галерея фото: статические файлы встроены в exe, данные берутся из /index
+	router.StaticFS("/gallery", web.Gallery())
+	router.GET("/", func(c *gin.Context) {
+		c.Redirect(http.StatusFound, "/gallery/")
+	})
+
+	return handlers
+}
@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"photo-sync-server/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Версия протокола возобновляемой загрузки (совместим с tus 1.0.0 в части core)
+const tusVersion = "1.0.0"
+
+// statusChecksumMismatch - код ответа tus при несовпадении хеша фрагмента
+const statusChecksumMismatch = 460
+
+// CreateUploadHandler создает возобновляемую загрузку.
+// Размер передается в заголовке Upload-Length, метаданные - в Upload-Metadata
+// (пары "ключ base64(значение)" через запятую): counterNumber, originalName, dateTaken, reading
+// и reading.<регистр> для многотарифных счетчиков (reading.T1, reading.T2).
+func (h *Handlers) CreateUploadHandler(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+		return
+	}
+
+	if _, exists := h.sessionStore.Get(token); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "valid Upload-Length header is required"})
+		return
+	}
+	if length > h.config.MaxUploadSize {
+		c.Header("Tus-Max-Size", strconv.FormatInt(h.config.MaxUploadSize, 10))
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "photo exceeds max upload size"})
+		return
+	}
+
+	metadata, err := parseUploadMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Подпись покрывает метаданные так же, как поля формы /sync: повтор перехваченного
+	// запроса с другим номером счетчика, датой или показанием не пройдет
+	meta := photoMeta{
+		CounterNumber: metadata["counterNumber"],
+		DateTaken:     metadata["dateTaken"],
+		Reading:       metadata["reading"],
+		Registers:     registerFields(metadata),
+	}
+	if !verifyFormSignature(c, meta.signedFields()...) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid request signature"})
+		return
+	}
+
+	upload, err := h.uploadStore.Create(token, length, meta.CounterNumber, metadata["originalName"], meta.DateTaken, meta.Reading, meta.Registers)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create upload"})
+		return
+	}
+
+	location := fmt.Sprintf("/uploads/%s?token=%s", upload.ID, token)
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Location", location)
+	c.Header("Upload-Offset", "0")
+	c.JSON(http.StatusCreated, gin.H{
+		"id":       upload.ID,
+		"location": location,
+		"offset":   upload.Offset,
+		"length":   upload.Length,
+	})
+}
+
+// HeadUploadHandler возвращает текущее смещение загрузки
+func (h *Handlers) HeadUploadHandler(c *gin.Context) {
+	upload, ok := h.lookupUpload(c)
+	if !ok {
+		return
+	}
+
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	c.Status(http.StatusOK)
+}
+
+// PatchUploadHandler дописывает очередной фрагмент по смещению Upload-Offset.
+// После получения последнего фрагмента фото проходит тот же конвейер, что и в /sync.
+func (h *Handlers) PatchUploadHandler(c *gin.Context) {
+	upload, ok := h.lookupUpload(c)
+	if !ok {
+		return
+	}
+
+	if contentType := c.ContentType(); contentType != "application/offset+octet-stream" {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be application/offset+octet-stream"})
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "valid Upload-Offset header is required"})
+		return
+	}
+
+	c.Header("Tus-Resumable", tusVersion)
+	updated, err := h.uploadStore.WriteChunk(upload.ID, offset, c.Request.Body, c.GetString(contentHashKey))
+	switch {
+	case errors.Is(err, storage.ErrUploadFinished):
+		// Повтор завершающего запроса (ответ потерялся): фото уже принято, возвращаем тот же ответ
+		c.Header("Upload-Offset", strconv.FormatInt(updated.Offset, 10))
+		if offset != updated.Length {
+			c.JSON(http.StatusConflict, gin.H{"error": storage.ErrOffsetMismatch.Error()})
+			return
+		}
+		c.Data(http.StatusOK, "application/json; charset=utf-8", updated.Result)
+		return
+	case errors.Is(err, storage.ErrChecksumMismatch):
+		c.Header("Upload-Offset", strconv.FormatInt(updated.Offset, 10))
+		c.JSON(statusChecksumMismatch, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, storage.ErrOffsetMismatch):
+		c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, storage.ErrUploadBusy):
+		c.JSON(http.StatusLocked, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, storage.ErrUploadNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		// Соединение оборвалось: полученная часть сохранена, клиент продолжит через HEAD
+		c.Header("Upload-Offset", strconv.FormatInt(updated.Offset, 10))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to write upload chunk"})
+		return
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(updated.Offset, 10))
+	if !updated.Complete() {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	h.finishUpload(c, updated)
+}
+
+// DeleteUploadHandler отменяет загрузку и удаляет полученные данные
+func (h *Handlers) DeleteUploadHandler(c *gin.Context) {
+	upload, ok := h.lookupUpload(c)
+	if !ok {
+		return
+	}
+
+	h.uploadStore.Remove(upload.ID)
+	c.Header("Tus-Resumable", tusVersion)
+	c.Status(http.StatusNoContent)
+}
+
+// finishUpload передает собранный файл в общий конвейер без копирования и сохраняет ответ,
+// чтобы повтор завершающего запроса не принимал фото второй раз
+func (h *Handlers) finishUpload(c *gin.Context, upload *storage.Upload) {
+	session, exists := h.sessionStore.Get(upload.Token)
+	if !exists {
+		h.uploadStore.Remove(upload.ID)
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	// Сбой после приема фото, но до Finish: файла загрузки уже нет, а фото есть в индексе.
+	// Повтор получает ответ как на дубликат, а не ошибку
+	if upload.Hash != "" {
+		if photo := h.indexer.FindByHash(upload.Hash); photo != nil {
+			result := &ingestResult{RelPath: photo.Path, IsDuplicate: true, Reason: "hash"}
+			h.respondUpload(c, upload, result.response(session))
+			return
+		}
+	}
+
+	incoming, err := h.fileManager.AdoptIncoming(h.uploadStore.PartPath(upload.ID))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// Данные потеряны при сбое до сохранения фото: загрузку нужно начать заново
+			h.uploadStore.Remove(upload.ID)
+			c.JSON(http.StatusGone, gin.H{"error": "upload data lost, start a new upload"})
+			return
+		}
+		h.uploadStore.Release(upload.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read upload"})
+		return
+	}
+	if err := h.uploadStore.SetHash(upload.ID, incoming.Hash); err != nil {
+		fmt.Printf("Warning: Failed to save upload hash %s: %v\n", upload.ID, err)
+	}
+
+	meta := photoMeta{
+		CounterNumber: upload.CounterNumber,
+		OriginalName:  upload.OriginalName,
+		DateTaken:     upload.DateTaken,
+		Reading:       upload.Reading,
+		Registers:     upload.Registers,
+	}
+
+	result, err := h.ingestPhoto(upload.Token, incoming, meta)
+	if err != nil {
+		// Данные загрузки уже забраны конвейером, продолжить ее нельзя
+		h.uploadStore.Remove(upload.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save file"})
+		return
+	}
+
+	h.respondUpload(c, upload, result.response(session))
+}
+
+// respondUpload сохраняет ответ на завершающий запрос загрузки и отправляет его
+func (h *Handlers) respondUpload(c *gin.Context, upload *storage.Upload, result gin.H) {
+	response, err := json.Marshal(result)
+	if err != nil {
+		h.uploadStore.Remove(upload.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encode response"})
+		return
+	}
+	if err := h.uploadStore.Finish(upload.ID, response); err != nil {
+		fmt.Printf("Warning: Failed to save upload result %s: %v\n", upload.ID, err)
+	}
+
+	c.Data(http.StatusOK, "application/json; charset=utf-8", response)
+}
+
+// lookupUpload находит загрузку по id из пути и проверяет, что она принадлежит сессии
+func (h *Handlers) lookupUpload(c *gin.Context) (*storage.Upload, bool) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+		return nil, false
+	}
+
+	upload, exists := h.uploadStore.Get(c.Param("id"))
+	if !exists || upload.Token != token {
+		c.JSON(http.StatusNotFound, gin.H{"error": "upload not found"})
+		return nil, false
+	}
+
+	if _, exists := h.sessionStore.Get(token); !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return nil, false
+	}
+
+	return upload, true
+}
+
+// parseUploadMetadata разбирает заголовок Upload-Metadata в формате tus
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), " ", 2)
+		if parts[0] == "" {
+			continue
+		}
+		value := ""
+		if len(parts) == 2 {
+			decoded, err := base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid Upload-Metadata value for %q", parts[0])
+			}
+			value = string(decoded)
+		}
+		metadata[parts[0]] = value
+	}
+
+	return metadata, nil
+}
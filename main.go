@@ -1,32 +1,82 @@
 package main
 
 import (
+	"crypto/tls"
+	"flag"
 	"fmt"
 	"log"
-	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"photo-sync-server/config"
+	"photo-sync-server/discovery"
 	"photo-sync-server/handlers"
+	"photo-sync-server/security"
 	"photo-sync-server/storage"
 )
 
-const (
-	DefaultPort = 8080
-	PhotosDir   = "meter"
-)
+// Version - версия сервера, объявляется в сети по mDNS.
+// При сборке релиза задается через -ldflags "-X main.Version=..."
+var Version = "1.0.0"
 
-var (
-	baseDir string
+const (
+	// PhotosDir - имя папки для фото, если photo_dir не задан в настройках
+	PhotosDir = "meter"
 )
 
 func main() {
+	// Подкоманды для обслуживания библиотеки фото
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate-layout":
+			runMigrateLayout(os.Args[2:])
+			return
+		case "regen-thumbnails":
+			runRegenThumbnails(os.Args[2:])
+			return
+		case "export":
+			runExport(os.Args[2:])
+			return
+		}
+	}
+
+	runServer(os.Args[1:])
+}
+
+// loadConfig читает настройки; при ошибке выводит ее и завершает программу
+func loadConfig(fs *flag.FlagSet, args []string) *config.Config {
+	cfg, err := config.Load(fs, args)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		logErrorAndExit("Invalid configuration: %v", err)
+	}
+	return cfg
+}
+
+// resolveDirectories определяет папку для фото и папку для индексов
+func resolveDirectories(cfg *config.Config) (string, string) {
+	// Папка задана в настройках: используем только ее, без запасных вариантов
+	if cfg.PhotoDir != "" {
+		baseDir, err := filepath.Abs(cfg.PhotoDir)
+		if err != nil {
+			logErrorAndExit("Invalid photo directory %s: %v", cfg.PhotoDir, err)
+		}
+		if !tryCreateAndWrite(baseDir) {
+			logErrorAndExit("Photo directory %s is not writable", baseDir)
+		}
+		log.Printf("Using photo directory: %s", baseDir)
+		return baseDir, resolveIndexDir(cfg, baseDir, true)
+	}
+
 	// Определяем базовую директорию для сохранения фото
 	// Пробуем несколько вариантов для гарантированных прав доступа
-	var err error
 	exePath, err := os.Executable()
 	if err != nil {
 		exePath = "."
@@ -34,7 +84,7 @@ func main() {
 	exeDir := filepath.Dir(exePath)
 	
 	// Вариант 1: Папка рядом с exe файлом (предпочтительно)
-	baseDir = filepath.Join(exeDir, PhotosDir)
+	baseDir := filepath.Join(exeDir, PhotosDir)
 	canWrite := tryCreateAndWrite(baseDir)
 	
 	// Вариант 2: Если не получилось, пробуем временную директорию
@@ -62,6 +112,24 @@ func main() {
 	
 	log.Printf("Using photo directory: %s", baseDir)
 
+	return baseDir, resolveIndexDir(cfg, baseDir, canWrite)
+}
+
+// resolveIndexDir определяет папку для индексов
+func resolveIndexDir(cfg *config.Config, baseDir string, canWrite bool) string {
+	// Папка задана в настройках
+	if cfg.IndexDir != "" {
+		indexDir, err := filepath.Abs(cfg.IndexDir)
+		if err != nil {
+			logErrorAndExit("Invalid index directory %s: %v", cfg.IndexDir, err)
+		}
+		if err := os.MkdirAll(indexDir, 0755); err != nil {
+			logErrorAndExit("Failed to create index directory %s: %v", indexDir, err)
+		}
+		log.Printf("Index directory: %s", indexDir)
+		return indexDir
+	}
+
 	// Определяем папку для индексов
 	var indexDir string
 	if canWrite {
@@ -88,11 +156,58 @@ func main() {
 	}
 	log.Printf("Index directory: %s", indexDir)
 
-	// Получаем локальный IP адрес
-	localIP, err := getLocalIP()
+	return indexDir
+}
+
+// runServer запускает HTTP сервер синхронизации
+func runServer(args []string) {
+	cfg := loadConfig(flag.NewFlagSet("photo-sync-server", flag.ContinueOnError), args)
+	cfg.Print(log.Printf)
+
+	baseDir, indexDir := resolveDirectories(cfg)
+
+	layout, err := storage.ParseLayout(cfg.Layout)
 	if err != nil {
-		log.Printf("Warning: Failed to get local IP: %v", err)
-		localIP = "localhost"
+		logErrorAndExit("Invalid layout template: %v", err)
+	}
+
+	// Ключ администратора: из настроек или сохраненный в папке индекса
+	if cfg.AdminKey == "" {
+		adminKey, created, err := security.LoadOrCreateAdminKey(indexDir)
+		if err != nil {
+			logErrorAndExit("Failed to prepare admin key: %v", err)
+		}
+		cfg.AdminKey = adminKey
+		if created {
+			log.Printf("Generated admin key: %s", adminKey)
+		}
+		log.Printf("Admin key is stored in %s", filepath.Join(indexDir, security.AdminKeyFile))
+	}
+	if cfg.AdminLocalhost {
+		log.Printf("Management endpoints are open from this PC (localhost) without the admin key")
+	}
+
+	// Адреса, по которым устройство может подключиться из локальной сети
+	addresses := resolveAddresses(cfg)
+	localIP := "localhost"
+	if len(addresses) > 0 {
+		localIP = addresses[0]
+	} else {
+		log.Printf("Warning: No network addresses found, the server is reachable only from this PC")
+	}
+	for _, address := range addresses {
+		log.Printf("Network address: %s", address)
+	}
+
+	// Сертификат для HTTPS: свой или самоподписанный из папки индекса
+	var tlsCert tls.Certificate
+	var tlsFingerprint string
+	if cfg.TLS {
+		tlsCert = loadCertificate(cfg, indexDir, addresses)
+		tlsFingerprint = security.Fingerprint(tlsCert)
+		log.Printf("TLS certificate SHA-256 fingerprint: %s", tlsFingerprint)
+	} else {
+		log.Printf("HTTPS is disabled, device traffic is not encrypted; enable it with tls: true (-tls)")
 	}
 
 	// Настраиваем Gin
@@ -103,22 +218,75 @@ func main() {
 	router.Use(corsMiddleware())
 
 	// Инициализируем хранилище сессий
-	sessionStore := storage.NewSessionStore()
+	sessionStore, err := storage.NewSessionStore(filepath.Join(indexDir, "sessions"), cfg.SessionTTL)
+	if err != nil {
+		logErrorAndExit("Failed to initialize session store: %v", err)
+	}
+	if restored := sessionStore.Count(); restored > 0 {
+		log.Printf("Restored %d sessions from %s", restored, filepath.Join(indexDir, "sessions"))
+	}
 
 	// Инициализируем хранилище файлов
-	fileManager := storage.NewFileManager(baseDir)
+	fileManager := storage.NewFileManager(baseDir, layout)
+
+	// Открываем индекс фото
+	indexer, err := storage.NewIndexer(indexDir)
+	if err != nil {
+		logErrorAndExit("Failed to open photo index: %v", err)
+	}
+	log.Printf("Photo index: %d photos", indexer.Count())
+
+	// Восстанавливаем базу хешей для проверки дубликатов из индекса
+	duplicateCheck := storage.NewDuplicateCheck()
+	report := duplicateCheck.LoadFromIndexer(indexer, fileManager)
+	log.Printf("Duplicate check: loaded %d hashes from index", report.Loaded)
+	if report.WithoutHash > 0 || report.MissingFiles > 0 {
+		log.Printf("Duplicate check: skipped %d entries without hash and %d entries with missing files", report.WithoutHash, report.MissingFiles)
+	}
+
+	// Инициализируем хранилище возобновляемых загрузок
+	uploadStore, err := storage.NewUploadStore(filepath.Join(indexDir, "uploads"), cfg.UploadTTL)
+	if err != nil {
+		logErrorAndExit("Failed to initialize upload store: %v", err)
+	}
+
+	// Инициализируем кеш миниатюр
+	thumbnails, err := storage.NewThumbnailCache(filepath.Join(indexDir, "thumbs"))
+	if err != nil {
+		logErrorAndExit("Failed to initialize thumbnail cache: %v", err)
+	}
 
-	// Инициализируем индексер
-	indexer := storage.NewIndexer(indexDir)
+	// Открываем реестр счетчиков
+	meters, err := storage.NewMeterRegistry(indexDir)
+	if err != nil {
+		logErrorAndExit("Failed to open meter registry: %v", err)
+	}
+	log.Printf("Meter registry: %d meters", meters.Count())
 
 	// Регистрируем обработчики
-	handlers.SetupRoutes(router, sessionStore, fileManager, indexer, localIP, DefaultPort)
+	h := handlers.SetupRoutes(router, cfg, sessionStore, fileManager, indexer, duplicateCheck, uploadStore, thumbnails, meters, addresses, tlsFingerprint)
 
 	// Запускаем сервер
-	addr := fmt.Sprintf(":%d", DefaultPort)
-	log.Printf("Photo sync server starting on http://%s:%d", localIP, DefaultPort)
+	log.Printf("Photo sync server starting on %s://%s:%d", cfg.Scheme(), localIP, cfg.Port())
 	log.Printf("Photos will be saved to: %s", baseDir)
-	log.Printf("To start sync, visit: http://localhost:%d/start", DefaultPort)
+	log.Printf("To start sync, visit: %s://localhost:%d/start", cfg.Scheme(), cfg.Port())
+	printPairingQR(h, cfg.SessionTTL)
+
+	// Объявляем сервер в локальной сети, чтобы приложение нашло его без ввода IP
+	var advertiser *discovery.Advertiser
+	if cfg.MDNS && len(addresses) > 0 {
+		advertiser, err = discovery.Advertise(discovery.Service{
+			Version:   Version,
+			Port:      cfg.Port(),
+			TLS:       cfg.TLS,
+			Addresses: addresses,
+		})
+		if err != nil {
+			log.Printf("Warning: Failed to start mDNS advertising: %v", err)
+		} else {
+			log.Printf("Advertising %s on the local network (mDNS)", discovery.ServiceType)
+		}
+	}
 
 	// Обработка сигналов для graceful shutdown
 	sigChan := make(chan os.Signal, 1)
@@ -127,14 +295,73 @@ func main() {
 	go func() {
 		<-sigChan
 		log.Println("\nShutting down server...")
+		if advertiser != nil {
+			advertiser.Shutdown()
+		}
+		indexer.Close()
+		meters.Close()
 		os.Exit(0)
 	}()
 
-	if err := router.Run(addr); err != nil {
+	server := &http.Server{
+		Addr:    cfg.Listen,
+		Handler: router,
+	}
+
+	if cfg.TLS {
+		server.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{tlsCert},
+			MinVersion:   tls.VersionTLS12,
+		}
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil {
 		logErrorAndExit("Failed to start server: %v", err)
 	}
 }
 
+// loadCertificate загружает сертификат из tls_cert/tls_key или самоподписанный из папки индекса
+func loadCertificate(cfg *config.Config, indexDir string, addresses []string) tls.Certificate {
+	if cfg.TLSCert != "" {
+		cert, err := security.LoadCertificate(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			logErrorAndExit("Failed to load TLS certificate: %v", err)
+		}
+		log.Printf("Using TLS certificate: %s", cfg.TLSCert)
+		return cert
+	}
+
+	cert, created, err := security.LoadOrCreateCertificate(indexDir, addresses)
+	if err != nil {
+		logErrorAndExit("Failed to prepare self-signed TLS certificate: %v", err)
+	}
+	if created {
+		log.Printf("Generated self-signed TLS certificate: %s", filepath.Join(indexDir, security.CertFile))
+	} else {
+		log.Printf("Using self-signed TLS certificate: %s", filepath.Join(indexDir, security.CertFile))
+	}
+	return cert
+}
+
+// printPairingQR создает сессию и выводит ее QR код в консоль,
+// чтобы устройство можно было подключить без браузера и интернета
+func printPairingQR(h *handlers.Handlers, ttl time.Duration) {
+	pairing, err := h.CreatePairing()
+	if err != nil {
+		log.Printf("Warning: Failed to create pairing session: %v", err)
+		return
+	}
+	qr, err := pairing.TerminalQR()
+	if err != nil {
+		log.Printf("Warning: Failed to generate pairing QR code: %v", err)
+		return
+	}
+	log.Printf("Scan this QR code in the app to pair (session %s, valid for %s):", pairing.Token, ttl)
+	fmt.Print(qr)
+}
+
 // tryCreateAndWrite пытается создать директорию и проверить права на запись
 func tryCreateAndWrite(dir string) bool {
 	// Создаем директорию если её нет
@@ -163,16 +390,19 @@ func logErrorAndExit(format string, args ...interface{}) {
 	os.Exit(1)
 }
 
-// getLocalIP получает локальный IP адрес для подключения из локальной сети
-func getLocalIP() (string, error) {
-	conn, err := net.Dial("udp", "8.8.8.8:80")
-	if err != nil {
-		return "", err
+// resolveAddresses возвращает адреса, по которым сервер доступен из локальной сети.
+// Если listen задает конкретный IP, используется только он.
+func resolveAddresses(cfg *config.Config) []string {
+	if host := cfg.ListenHost(); host != "" {
+		return []string{host}
 	}
-	defer conn.Close()
 
-	localAddr := conn.LocalAddr().(*net.UDPAddr)
-	return localAddr.IP.String(), nil
+	addresses, err := discovery.LocalAddresses()
+	if err != nil {
+		log.Printf("Warning: Failed to list network interfaces: %v", err)
+		return nil
+	}
+	return addresses
 }
 
 // corsMiddleware настраивает CORS для работы с браузером
@@ -180,8 +410,9 @@ func corsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-Admin-Key, X-Sync-Timestamp, X-Sync-Signature, X-Content-SHA256, Tus-Resumable, Upload-Length, Upload-Offset, Upload-Metadata")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE, PATCH, HEAD")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "Location, Tus-Resumable, Upload-Length, Upload-Offset, X-Sync-Token, ETag, Content-Range, Accept-Ranges")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -0,0 +1,270 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CommentVersion - текущая версия структурированного формата USER_COMMENT
+const CommentVersion = 1
+
+// Comment - данные, записанные приложением в USER_COMMENT.
+//
+// Поддерживаются три формата:
+//   - JSON: {"v":1,"counter":"0011067128","reading":"12345.6","apartment":"12","inspector":"A17","note":"..."}
+//   - пары key=value через ";": v=1;counter=0011067128;reading=12345.6;apartment=12
+//   - прежний формат: весь комментарий - номер счетчика (Version = 0)
+//
+// Показания многотарифных счетчиков и пар ГВС/ХВС передаются по регистрам:
+// reading.t1=1234;reading.t2=567 или {"readings":{"t1":1234,"t2":567}}.
+type Comment struct {
+	Version       int
+	CounterNumber string
+	Reading       *float64
+	Registers     map[string]float64 // показания именованных регистров (t1, t2, hot, cold)
+	Apartment     string
+	InspectorID   string
+	Notes         string
+	Extra         map[string]string // неизвестные ключи сохраняются для будущих версий
+}
+
+// commentKeys сопоставляет ключи и их синонимы с полями Comment
+var commentKeys = map[string]string{
+	"v":             "v",
+	"version":       "v",
+	"counter":       "counter",
+	"counternumber": "counter",
+	"reading":       "reading",
+	"value":         "reading",
+	"readings":      "reading",
+	"apartment":     "apartment",
+	"apt":           "apartment",
+	"inspector":     "inspector",
+	"inspectorid":   "inspector",
+	"note":          "note",
+	"notes":         "note",
+}
+
+// ParseComment разбирает USER_COMMENT. Комментарий без JSON и без "=" считается
+// номером счетчика в прежнем формате. Поля разбираются по отдельности: неверное поле
+// (показание не число, неверная версия, неверное название регистра) пропускается и
+// описывается в errs, остальные поля, в том числе номер счетчика, сохраняются.
+// Из поврежденного JSON берутся поля, записанные до места повреждения.
+func ParseComment(comment string) (result *Comment, errs []error) {
+	comment = strings.TrimSpace(comment)
+	if comment == "" {
+		return &Comment{}, nil
+	}
+
+	var fields map[string]string
+	switch {
+	case strings.HasPrefix(comment, "{"):
+		fields, errs = parseJSONComment(comment)
+	case strings.Contains(comment, "="):
+		fields = parsePairsComment(comment)
+	default:
+		return &Comment{CounterNumber: comment}, nil
+	}
+
+	result = &Comment{Version: CommentVersion}
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := result.setField(key, fields[key]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return result, errs
+}
+
+// setField записывает поле комментария; неверное значение не меняет Comment
+func (c *Comment) setField(key, value string) error {
+	if name, ok := RegisterField(key); ok {
+		if value == "" {
+			return nil
+		}
+		register, err := NormalizeRegister(name)
+		if err != nil {
+			return err
+		}
+		reading, err := ParseReading(value)
+		if err != nil {
+			return fmt.Errorf("register %s: %w", register, err)
+		}
+		if register == DefaultRegister {
+			c.Reading = &reading
+			return nil
+		}
+		if c.Registers == nil {
+			c.Registers = make(map[string]float64)
+		}
+		c.Registers[register] = reading
+		return nil
+	}
+
+	switch commentKeys[strings.ToLower(key)] {
+	case "v":
+		version, err := strconv.Atoi(value)
+		if err != nil || version < 1 {
+			return fmt.Errorf("invalid comment version %q", value)
+		}
+		c.Version = version
+		if version > CommentVersion {
+			return fmt.Errorf("comment version %d is newer than supported version %d, only known fields were read", version, CommentVersion)
+		}
+	case "counter":
+		c.CounterNumber = value
+	case "reading":
+		if value == "" {
+			return nil
+		}
+		reading, err := ParseReading(value)
+		if err != nil {
+			return err
+		}
+		c.Reading = &reading
+	case "apartment":
+		c.Apartment = value
+	case "inspector":
+		c.InspectorID = value
+	case "note":
+		c.Notes = value
+	default:
+		if c.Extra == nil {
+			c.Extra = make(map[string]string)
+		}
+		c.Extra[key] = value
+	}
+	return nil
+}
+
+// Readings возвращает все показания комментария по регистрам.
+// Показание без регистра относится к DefaultRegister.
+func (c *Comment) Readings() map[string]float64 {
+	readings := make(map[string]float64, len(c.Registers)+1)
+	for register, value := range c.Registers {
+		readings[register] = value
+	}
+	if c.Reading != nil {
+		readings[DefaultRegister] = *c.Reading
+	}
+	return readings
+}
+
+// ParseReading разбирает показание счетчика: допускаются пробелы между разрядами
+// и запятая в качестве десятичного разделителя. NaN и бесконечность не принимаются.
+func ParseReading(value string) (float64, error) {
+	normalized := strings.NewReplacer(" ", "", " ", "", ",", ".").Replace(strings.TrimSpace(value))
+	reading, err := strconv.ParseFloat(normalized, 64)
+	if err != nil || reading < 0 || math.IsNaN(reading) || math.IsInf(reading, 0) {
+		return 0, fmt.Errorf("invalid reading %q", value)
+	}
+	return reading, nil
+}
+
+// parseJSONComment разбирает JSON объект; числа и строки приводятся к строкам, null - к пустой строке.
+// Вложенный объект показаний {"readings":{"t1":1}} разворачивается в ключи "readings.t1".
+// Значения другого типа пропускаются с ошибкой; если JSON поврежден, возвращаются поля до повреждения.
+func parseJSONComment(comment string) (map[string]string, []error) {
+	fields := make(map[string]string)
+	var errs []error
+
+	decoder := json.NewDecoder(strings.NewReader(comment))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return fields, []error{fmt.Errorf("invalid JSON comment: not an object")}
+	}
+	// Если JSON поврежден сразу за числом, число могло быть обрезано ("reading":12a читается
+	// как 12), поэтому такое последнее поле отбрасывается
+	lastNumber := ""
+	broken := func(err error) (map[string]string, []error) {
+		if lastNumber != "" {
+			delete(fields, lastNumber)
+		}
+		return fields, append(errs, fmt.Errorf("invalid JSON comment: %w", err))
+	}
+
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return broken(err)
+		}
+		key, _ := token.(string)
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return broken(err)
+		}
+
+		lastNumber = ""
+		if registers, ok := jsonRegisters(key, value); ok {
+			for register, reading := range registers {
+				text, err := jsonScalar(reading)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("invalid JSON comment: reading of register %q must be a string or a number", register))
+					continue
+				}
+				fields[key+"."+register] = text
+			}
+			continue
+		}
+		text, err := jsonScalar(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid JSON comment: value of %q must be a string or a number", key))
+			continue
+		}
+		fields[key] = text
+		if value[0] != '"' {
+			lastNumber = key
+		}
+	}
+	if _, err := decoder.Token(); err != nil {
+		return broken(err)
+	}
+	return fields, errs
+}
+
+// jsonRegisters возвращает объект показаний по регистрам, если key - "reading" или "readings"
+func jsonRegisters(key string, value json.RawMessage) (map[string]json.RawMessage, bool) {
+	if commentKeys[strings.ToLower(key)] != "reading" {
+		return nil, false
+	}
+	var registers map[string]json.RawMessage
+	if err := json.Unmarshal(value, &registers); err != nil || registers == nil {
+		return nil, false
+	}
+	return registers, true
+}
+
+// jsonScalar приводит строку или число JSON к строке, null - к пустой строке
+func jsonScalar(value json.RawMessage) (string, error) {
+	var text string
+	if err := json.Unmarshal(value, &text); err == nil {
+		return strings.TrimSpace(text), nil
+	}
+	var number json.Number
+	if err := json.Unmarshal(value, &number); err == nil {
+		return number.String(), nil
+	}
+	return "", fmt.Errorf("not a string or a number")
+}
+
+// parsePairsComment разбирает пары key=value через ";". Пары без "=" пропускаются.
+func parsePairsComment(comment string) map[string]string {
+	fields := make(map[string]string)
+	for _, pair := range strings.Split(comment, ";") {
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			continue
+		}
+		fields[key] = strings.TrimSpace(value)
+	}
+	return fields
+}
@@ -0,0 +1,107 @@
+package metadata
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseReading(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    float64
+		wantErr bool
+	}{
+		{"12345.67", 12345.67, false},
+		{"12 345,67", 12345.67, false},
+		{" 0 ", 0, false},
+		{"-1", 0, true},
+		{"abc", 0, true},
+		{"", 0, true},
+		{"NaN", 0, true},
+		{"nan", 0, true},
+		{"Inf", 0, true},
+		{"+Inf", 0, true},
+		{"infinity", 0, true},
+		{"1e400", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseReading(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseReading(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ParseReading(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func float(value float64) *float64 { return &value }
+
+func TestParseComment(t *testing.T) {
+	tests := []struct {
+		name    string
+		comment string
+		want    Comment
+		errs    int // сколько полей пропущено с ошибкой
+	}{
+		{"empty", "  ", Comment{}, 0},
+		{"legacy counter", "0011067128", Comment{CounterNumber: "0011067128"}, 0},
+		{"legacy counter with spaces", " 00-11 067 ", Comment{CounterNumber: "00-11 067"}, 0},
+
+		{"json", `{"v":1,"counter":"0011067128","reading":"12 345,67","apartment":"12","inspector":"A17","note":"пломба; на месте"}`,
+			Comment{Version: 1, CounterNumber: "0011067128", Reading: float(12345.67), Apartment: "12", InspectorID: "A17", Notes: "пломба; на месте"}, 0},
+		{"json numbers and synonyms", `{"version":1,"counterNumber":"0011067","value":42.5,"apt":7,"inspectorId":"B2","notes":null}`,
+			Comment{Version: 1, CounterNumber: "0011067", Reading: float(42.5), Apartment: "7", InspectorID: "B2"}, 0},
+		{"json registers", `{"counter":"0011067","readings":{"T1":"1 234,5","t2":567}}`,
+			Comment{Version: 1, CounterNumber: "0011067", Registers: map[string]float64{"t1": 1234.5, "t2": 567}}, 0},
+		{"json main register", `{"counter":"0011067","readings":{"main":10,"hot":3}}`,
+			Comment{Version: 1, CounterNumber: "0011067", Reading: float(10), Registers: map[string]float64{"hot": 3}}, 0},
+		{"json unknown key", `{"counter":"0011067","seal":"ok"}`,
+			Comment{Version: 1, CounterNumber: "0011067", Extra: map[string]string{"seal": "ok"}}, 0},
+
+		{"pairs", "v=1;counter=0011067128;reading=12345.67;apartment=12;inspector=A17;note=пломба на месте",
+			Comment{Version: 1, CounterNumber: "0011067128", Reading: float(12345.67), Apartment: "12", InspectorID: "A17", Notes: "пломба на месте"}, 0},
+		{"pairs without version", " counter = 0011067 ; reading = 5 ; broken ",
+			Comment{Version: 1, CounterNumber: "0011067", Reading: float(5)}, 0},
+		{"pairs registers", "counter=0011067;reading.T1=100;readings.t2=200;reading.hot=",
+			Comment{Version: 1, CounterNumber: "0011067", Registers: map[string]float64{"t1": 100, "t2": 200}}, 0},
+
+		// Неверные поля пропускаются, остальные сохраняются
+		{"invalid version", "v=0;counter=0011067;reading=5",
+			Comment{Version: 1, CounterNumber: "0011067", Reading: float(5)}, 1},
+		{"non-numeric version", `{"v":"one","counter":"0011067"}`,
+			Comment{Version: 1, CounterNumber: "0011067"}, 1},
+		{"unknown version", "v=2;counter=0011067;reading=5",
+			Comment{Version: 2, CounterNumber: "0011067", Reading: float(5)}, 1},
+		{"invalid reading", "counter=0011067;reading=12x;apt=4",
+			Comment{Version: 1, CounterNumber: "0011067", Apartment: "4"}, 1},
+		{"NaN reading", `{"counter":"0011067","reading":"NaN"}`,
+			Comment{Version: 1, CounterNumber: "0011067"}, 1},
+		{"invalid register name", "counter=0011067;reading.t 1=5;reading.t2=6",
+			Comment{Version: 1, CounterNumber: "0011067", Registers: map[string]float64{"t2": 6}}, 1},
+		{"invalid register reading", `{"counter":"0011067","readings":{"t1":"abc","t2":[2],"t3":3}}`,
+			Comment{Version: 1, CounterNumber: "0011067", Registers: map[string]float64{"t3": 3}}, 2},
+		{"json object value", `{"counter":"0011067","apartment":{"n":1}}`,
+			Comment{Version: 1, CounterNumber: "0011067"}, 1},
+		{"broken json", `{"counter":"0011067","apartment":"12","reading":`,
+			Comment{Version: 1, CounterNumber: "0011067", Apartment: "12"}, 1},
+		{"broken json after number", `{"counter":"0011067","reading":12a}`,
+			Comment{Version: 1, CounterNumber: "0011067"}, 1},
+		{"unclosed json", `{"counter":"0011067","apartment":"12"`,
+			Comment{Version: 1, CounterNumber: "0011067", Apartment: "12"}, 1},
+		{"json not an object", `{]`, Comment{Version: 1}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, errs := ParseComment(tt.comment)
+			if len(errs) != tt.errs {
+				t.Errorf("ParseComment() errors = %v, want %d", errs, tt.errs)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("ParseComment() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
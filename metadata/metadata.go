@@ -0,0 +1,183 @@
+// Package metadata читает метаданные фото из EXIF
+package metadata
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+// ErrNoEXIF возвращается, если в файле нет EXIF или его не удалось разобрать
+var ErrNoEXIF = errors.New("no EXIF metadata")
+
+// Metadata содержит метаданные фото из EXIF
+type Metadata struct {
+	UserComment string    // декодированный USER_COMMENT
+	DateTaken   time.Time // нулевое значение, если даты нет
+	CameraMake  string
+	CameraModel string
+	Orientation int // 1-8, 0 если тега нет
+	Width       int
+	Height      int
+	GPS         *GPSPosition
+}
+
+// GPSPosition - координаты съемки
+type GPSPosition struct {
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	Altitude  *float64 `json:"altitude,omitempty"` // метры над уровнем моря
+}
+
+// exifDateLayout - формат дат EXIF
+const exifDateLayout = "2006:01:02 15:04:05"
+
+// Теги смещения часового пояса (EXIF 2.31), goexif их не знает
+const (
+	offsetTime         exif.FieldName = "OffsetTime"
+	offsetTimeOriginal exif.FieldName = "OffsetTimeOriginal"
+)
+
+var offsetTimeFields = map[uint16]exif.FieldName{
+	0x9010: offsetTime,
+	0x9011: offsetTimeOriginal,
+}
+
+// offsetTimeParser дочитывает теги OffsetTime* из Exif sub-IFD
+type offsetTimeParser struct{}
+
+func (offsetTimeParser) Parse(x *exif.Exif) error {
+	tag, err := x.Get(exif.ExifIFDPointer)
+	if err != nil {
+		return nil
+	}
+	offset, err := tag.Int64(0)
+	if err != nil {
+		return nil
+	}
+	r := bytes.NewReader(x.Raw)
+	if _, err := r.Seek(offset, 0); err != nil {
+		return nil
+	}
+	dir, _, err := tiff.DecodeDir(r, x.Tiff.Order)
+	if err != nil {
+		return nil
+	}
+	x.LoadTags(dir, offsetTimeFields, false)
+	return nil
+}
+
+func init() {
+	exif.RegisterParsers(offsetTimeParser{})
+}
+
+// Read разбирает EXIF из начала файла (JPEG или TIFF).
+// Отсутствующие или поврежденные теги пропускаются, ошибка возвращается только без EXIF.
+func Read(data []byte) (*Metadata, error) {
+	x, err := exif.Decode(bytes.NewReader(data))
+	if x == nil || (err != nil && exif.IsCriticalError(err)) {
+		return nil, ErrNoEXIF
+	}
+
+	meta := &Metadata{
+		DateTaken:   dateTaken(x),
+		CameraMake:  stringTag(x, exif.Make),
+		CameraModel: stringTag(x, exif.Model),
+		Orientation: intTag(x, exif.Orientation),
+		Width:       intTag(x, exif.PixelXDimension),
+		Height:      intTag(x, exif.PixelYDimension),
+		GPS:         gpsPosition(x),
+	}
+	if tag, err := x.Get(exif.UserComment); err == nil {
+		meta.UserComment = DecodeUserComment(tag.Val, x.Tiff.Order)
+	}
+	return meta, nil
+}
+
+// ReadOrientation возвращает тег Orientation (1, если тега нет или он некорректен)
+func ReadOrientation(data []byte) int {
+	meta, err := Read(data)
+	if err != nil || meta.Orientation < 1 || meta.Orientation > 8 {
+		return 1
+	}
+	return meta.Orientation
+}
+
+// dateTaken возвращает DateTimeOriginal с учетом OffsetTimeOriginal.
+// Без смещения дата считается местным временем сервера (телефон и ПК обычно в одном поясе).
+func dateTaken(x *exif.Exif) time.Time {
+	value := stringTag(x, exif.DateTimeOriginal)
+	offset := stringTag(x, offsetTimeOriginal)
+	if value == "" {
+		value = stringTag(x, exif.DateTime)
+		offset = stringTag(x, offsetTime)
+	}
+	if value == "" {
+		return time.Time{}
+	}
+
+	location := time.Local
+	if offset != "" {
+		if parsed, err := time.Parse("-07:00", offset); err == nil {
+			_, seconds := parsed.Zone()
+			location = time.FixedZone(offset, seconds)
+		}
+	}
+
+	date, err := time.ParseInLocation(exifDateLayout, value, location)
+	if err != nil {
+		return time.Time{}
+	}
+	return date
+}
+
+// gpsPosition возвращает координаты съемки (nil, если их нет)
+func gpsPosition(x *exif.Exif) *GPSPosition {
+	lat, long, err := x.LatLong()
+	if err != nil {
+		return nil
+	}
+
+	position := &GPSPosition{Latitude: lat, Longitude: long}
+	if tag, err := x.Get(exif.GPSAltitude); err == nil {
+		if altitude, err := tag.Rat(0); err == nil {
+			value, _ := altitude.Float64()
+			// GPSAltitudeRef = 1 - высота ниже уровня моря
+			if intTag(x, exif.GPSAltitudeRef) == 1 {
+				value = -value
+			}
+			position.Altitude = &value
+		}
+	}
+	return position
+}
+
+// stringTag возвращает строковый тег без завершающих нулей и пробелов
+func stringTag(x *exif.Exif, name exif.FieldName) string {
+	tag, err := x.Get(name)
+	if err != nil {
+		return ""
+	}
+	value, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(value, "\x00"))
+}
+
+// intTag возвращает целочисленный тег (0, если тега нет)
+func intTag(x *exif.Exif, name exif.FieldName) int {
+	tag, err := x.Get(name)
+	if err != nil {
+		return 0
+	}
+	value, err := tag.Int(0)
+	if err != nil {
+		return 0
+	}
+	return value
+}
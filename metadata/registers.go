@@ -0,0 +1,37 @@
+package metadata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultRegister - регистр показания, переданного одним числом без названия регистра
+const DefaultRegister = "main"
+
+// maxRegisterLength ограничивает длину названия регистра
+const maxRegisterLength = 32
+
+// NormalizeRegister приводит название регистра к каноническому виду: "T1" и " t1 " - один регистр.
+// Допускаются латинские буквы, цифры, "_" и "-".
+func NormalizeRegister(name string) (string, error) {
+	register := strings.ToLower(strings.TrimSpace(name))
+	if register == "" || len(register) > maxRegisterLength {
+		return "", fmt.Errorf("invalid register name %q", name)
+	}
+	for _, r := range register {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return "", fmt.Errorf("invalid register name %q", name)
+		}
+	}
+	return register, nil
+}
+
+// RegisterField отделяет название регистра от имени поля "reading.T1" или "readings.T1"
+// (поле формы, ключ Upload-Metadata или ключ USER_COMMENT)
+func RegisterField(field string) (string, bool) {
+	prefix, name, ok := strings.Cut(field, ".")
+	if !ok || commentKeys[strings.ToLower(prefix)] != "reading" {
+		return "", false
+	}
+	return name, true
+}
@@ -0,0 +1,204 @@
+// Генератор фикстур testdata для тестов USER_COMMENT.
+//
+// Запуск из каталога metadata: go generate (или go run ./testdata/gen testdata).
+//
+// Каждый файл - JPEG 64x48 с сегментом APP1 "Exif\0\0" сразу после SOI.
+// TIFF внутри APP1 (смещения от начала TIFF-заголовка):
+//
+//	0   "II*\0" или "MM\0*", смещение IFD0 = 8
+//	8   IFD0: Make "Xiaomi", Model "Redmi Note 12", Orientation 1,
+//	    ExifIFDPointer -> Exif IFD сразу за IFD0
+//	    Exif IFD: DateTimeOriginal "2025:03:04 10:11:12", OffsetTimeOriginal "+03:00",
+//	    UserComment (UNDEFINED), PixelXDimension 4000, PixelYDimension 3000
+//
+// Записи IFD по 12 байт (тег, тип, количество, значение или смещение),
+// значения длиннее 4 байт лежат сразу за IFD и выравниваются до четного смещения.
+// UserComment - 8 байт кода кодировки и текст без завершающего нуля.
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"unicode/utf16"
+)
+
+const text = "Счетчик 0011067"
+
+// fixture описывает один файл: порядок байт EXIF и сырой UserComment
+type fixture struct {
+	file    string
+	order   binary.ByteOrder
+	comment []byte
+}
+
+var (
+	le = binary.LittleEndian
+	be = binary.BigEndian
+)
+
+var fixtures = []fixture{
+	{"ascii.jpg", le, join("ASCII\x00\x00\x00", []byte("counter=0011067;reading=123.45"))},
+	{"utf8.jpg", le, join("ASCII\x00\x00\x00", []byte(text))},
+	{"utf16le.jpg", le, join("UNICODE\x00", utf16Bytes(text, le))},
+	{"utf16be.jpg", be, join("UNICODE\x00", utf16Bytes(text, be))},
+	{"utf16le_bom.jpg", be, join("UNICODE\x00\xff\xfe", utf16Bytes(text, le))},
+	{"utf16be_bom.jpg", le, join("UNICODE\x00\xfe\xff", utf16Bytes(text, be))},
+	// ISO-2022-JP: "счетчик" в строке 7 JIS X 0208
+	{"jis.jpg", le, join("JIS\x00\x00\x00\x00\x00", []byte("\x1b$B'c'i'V'd'i'Z'\\\x1b(B 0011067"))},
+	// EUC-JP: "カウンタ"
+	{"jis_euc.jpg", le, join("JIS\x00\x00\x00\x00\x00", []byte("\xa5\xab\xa5\xa6\xa5\xf3\xa5\xbf 0011067"))},
+	{"undefined.jpg", le, join("\x00\x00\x00\x00\x00\x00\x00\x00", []byte(text))},
+}
+
+func join(code string, data []byte) []byte {
+	return append([]byte(code), data...)
+}
+
+func utf16Bytes(s string, order binary.ByteOrder) []byte {
+	var data []byte
+	for _, unit := range utf16.Encode([]rune(s)) {
+		b := make([]byte, 2)
+		order.PutUint16(b, unit)
+		data = append(data, b...)
+	}
+	return data
+}
+
+// entry - запись IFD, data уже в порядке байт файла
+type entry struct {
+	tag, typ uint16
+	count    uint32
+	data     []byte
+}
+
+func asciiEntry(tag uint16, s string) entry {
+	data := append([]byte(s), 0)
+	return entry{tag, 2, uint32(len(data)), data}
+}
+
+func shortEntry(order binary.ByteOrder, tag, v uint16) entry {
+	data := make([]byte, 2)
+	order.PutUint16(data, v)
+	return entry{tag, 3, 1, data}
+}
+
+func longEntry(order binary.ByteOrder, tag uint16, v uint32) entry {
+	data := make([]byte, 4)
+	order.PutUint32(data, v)
+	return entry{tag, 4, 1, data}
+}
+
+// ifdSize возвращает размер IFD вместе с вынесенными значениями
+func ifdSize(entries []entry) int {
+	size := 2 + 12*len(entries) + 4
+	for _, e := range entries {
+		if len(e.data) > 4 {
+			size += len(e.data) + len(e.data)%2
+		}
+	}
+	return size
+}
+
+// writeIFD пишет IFD, расположенный по смещению base от начала TIFF
+func writeIFD(buf *bytes.Buffer, order binary.ByteOrder, base int, entries []entry) {
+	b := make([]byte, 2)
+	order.PutUint16(b, uint16(len(entries)))
+	buf.Write(b)
+
+	valueOffset := base + 2 + 12*len(entries) + 4
+	var values bytes.Buffer
+	for _, e := range entries {
+		h := make([]byte, 12)
+		order.PutUint16(h, e.tag)
+		order.PutUint16(h[2:], e.typ)
+		order.PutUint32(h[4:], e.count)
+		if len(e.data) <= 4 {
+			copy(h[8:], e.data)
+		} else {
+			order.PutUint32(h[8:], uint32(valueOffset+values.Len()))
+			values.Write(e.data)
+			if len(e.data)%2 == 1 {
+				values.WriteByte(0)
+			}
+		}
+		buf.Write(h)
+	}
+	buf.Write([]byte{0, 0, 0, 0}) // следующего IFD нет
+	buf.Write(values.Bytes())
+}
+
+func buildTIFF(order binary.ByteOrder, comment []byte) []byte {
+	ifd0 := []entry{
+		asciiEntry(0x010F, "Xiaomi"),
+		asciiEntry(0x0110, "Redmi Note 12"),
+		shortEntry(order, 0x0112, 1),
+		longEntry(order, 0x8769, 0),
+	}
+	exifIFD := []entry{
+		asciiEntry(0x9003, "2025:03:04 10:11:12"),
+		asciiEntry(0x9011, "+03:00"),
+		{0x9286, 7, uint32(len(comment)), comment},
+		longEntry(order, 0xA002, 4000),
+		longEntry(order, 0xA003, 3000),
+	}
+	const ifd0Offset = 8
+	exifOffset := ifd0Offset + ifdSize(ifd0)
+	order.PutUint32(ifd0[3].data, uint32(exifOffset))
+
+	var buf bytes.Buffer
+	if order == be {
+		buf.WriteString("MM\x00\x2a")
+	} else {
+		buf.WriteString("II\x2a\x00")
+	}
+	b := make([]byte, 4)
+	order.PutUint32(b, ifd0Offset)
+	buf.Write(b)
+	writeIFD(&buf, order, ifd0Offset, ifd0)
+	writeIFD(&buf, order, exifOffset, exifIFD)
+	return buf.Bytes()
+}
+
+// buildJPEG вставляет APP1 с EXIF после SOI небольшого JPEG
+func buildJPEG(tiff []byte) ([]byte, error) {
+	img := image.NewGray(image.Rect(0, 0, 64, 48))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7)
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, nil); err != nil {
+		return nil, err
+	}
+
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+	var out bytes.Buffer
+	out.Write([]byte{0xFF, 0xD8, 0xFF, 0xE1})
+	length := make([]byte, 2)
+	binary.BigEndian.PutUint16(length, uint16(len(app1)+2))
+	out.Write(length)
+	out.Write(app1)
+	out.Write(encoded.Bytes()[2:])
+	return out.Bytes(), nil
+}
+
+func main() {
+	dir := "."
+	if len(os.Args) > 1 {
+		dir = os.Args[1]
+	}
+	for _, f := range fixtures {
+		data, err := buildJPEG(buildTIFF(f.order, f.comment))
+		if err == nil {
+			err = os.WriteFile(filepath.Join(dir, f.file), data, 0644)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", f.file, err)
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Коды кодировки в первых 8 байтах USER_COMMENT (EXIF 2.3, 4.6.5)
+var (
+	charsetASCII     = []byte("ASCII\x00\x00\x00")
+	charsetUnicode   = []byte("UNICODE\x00")
+	charsetJIS       = []byte("JIS\x00\x00\x00\x00\x00")
+	charsetUndefined = make([]byte, 8)
+)
+
+// DecodeUserComment декодирует значение тега USER_COMMENT.
+// Первые 8 байт задают кодировку:
+//   - ASCII - текст; приложения часто пишут сюда UTF-8, поэтому корректный UTF-8 сохраняется
+//   - UNICODE - UTF-16 с BOM или без; порядок байт без BOM определяется по содержимому,
+//     при равенстве берется порядок байт EXIF (order)
+//   - JIS - ISO-2022-JP или EUC-JP: ASCII, цифры, латиница, кана и кириллица JIS X 0208
+//   - нули (не определена) и значения без кода - UTF-8 или Latin-1
+//
+// Завершающие нули и пробелы удаляются.
+func DecodeUserComment(raw []byte, order binary.ByteOrder) string {
+	if order == nil {
+		order = binary.BigEndian
+	}
+
+	var text string
+	switch {
+	case len(raw) < 8:
+		text = decodeText(raw)
+	case bytes.Equal(raw[:8], charsetASCII), bytes.Equal(raw[:8], charsetUndefined):
+		text = decodeText(raw[8:])
+	case bytes.Equal(raw[:8], charsetUnicode):
+		text = decodeUTF16(raw[8:], order)
+	case bytes.Equal(raw[:8], charsetJIS):
+		text = decodeJIS(raw[8:])
+	default:
+		text = decodeText(raw)
+	}
+
+	return strings.TrimRight(text, "\x00 \t\r\n")
+}
+
+// decodeText декодирует однобайтовый текст: UTF-8, а если он некорректен - Latin-1
+func decodeText(data []byte) string {
+	data = trimNulls(data)
+	if utf8.Valid(data) {
+		return string(data)
+	}
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+// decodeUTF16 декодирует UTF-16 с учетом BOM
+func decodeUTF16(data []byte, order binary.ByteOrder) string {
+	switch {
+	case len(data) >= 2 && data[0] == 0xFE && data[1] == 0xFF:
+		return utf16String(data[2:], binary.BigEndian)
+	case len(data) >= 2 && data[0] == 0xFF && data[1] == 0xFE:
+		return utf16String(data[2:], binary.LittleEndian)
+	}
+
+	// Без BOM спецификация предписывает порядок байт EXIF, но часть приложений
+	// пишет UTF-16 в своем порядке. Выбираем вариант, больше похожий на текст.
+	other := binary.ByteOrder(binary.LittleEndian)
+	if order == binary.LittleEndian {
+		other = binary.BigEndian
+	}
+	preferred := utf16String(data, order)
+	alternative := utf16String(data, other)
+	if textScore(alternative) > textScore(preferred) {
+		return alternative
+	}
+	return preferred
+}
+
+func utf16String(data []byte, order binary.ByteOrder) string {
+	units := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		unit := order.Uint16(data[i:])
+		if unit == 0 {
+			break
+		}
+		units = append(units, unit)
+	}
+	return string(utf16.Decode(units))
+}
+
+// textScore оценивает, насколько строка похожа на комментарий:
+// латиница, цифры, кириллица и пунктуация повышают оценку
+func textScore(text string) int {
+	score := 0
+	for _, r := range text {
+		switch {
+		case r < 0x80 && unicode.IsPrint(r):
+			score += 2
+		case unicode.Is(unicode.Cyrillic, r):
+			score += 2
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r):
+			score++
+		default:
+			score--
+		}
+	}
+	return score
+}
+
+// decodeJIS декодирует комментарий в кодировке JIS (ISO-2022-JP или EUC-JP).
+// Символы JIS X 0208 вне поддержанных строк таблицы заменяются на U+FFFD.
+func decodeJIS(data []byte) string {
+	data = trimNulls(data)
+	var result strings.Builder
+
+	// EUC-JP: два байта со старшим битом - символ JIS X 0208
+	if bytes.IndexByte(data, 0x1B) < 0 {
+		if utf8.Valid(data) {
+			return string(data)
+		}
+		for i := 0; i < len(data); i++ {
+			if data[i] < 0x80 {
+				result.WriteByte(data[i])
+			} else if i+1 < len(data) {
+				result.WriteRune(jisRune(data[i]&0x7F, data[i+1]&0x7F))
+				i++
+			}
+		}
+		return result.String()
+	}
+
+	// ISO-2022-JP: режим переключается escape-последовательностями
+	doubleByte := false
+	for i := 0; i < len(data); i++ {
+		if data[i] == 0x1B && i+2 < len(data) {
+			switch string(data[i+1 : i+3]) {
+			case "$@", "$B":
+				doubleByte = true
+			case "(B", "(J":
+				doubleByte = false
+			}
+			i += 2
+			continue
+		}
+		if !doubleByte {
+			result.WriteByte(data[i])
+		} else if i+1 < len(data) {
+			result.WriteRune(jisRune(data[i], data[i+1]))
+			i++
+		}
+	}
+	return result.String()
+}
+
+// jisRune переводит символ JIS X 0208 (ряд, ячейка) в Unicode.
+// Полноширинные цифры и латиница приводятся к ASCII, чтобы номера счетчиков сравнивались как обычно.
+func jisRune(row, cell byte) rune {
+	switch {
+	case row == 0x21 && cell == 0x21:
+		return ' '
+	case row == 0x23 && (cell >= '0' && cell <= '9' || cell >= 'A' && cell <= 'Z' || cell >= 'a' && cell <= 'z'):
+		return rune(cell)
+	case row == 0x24 && cell >= 0x21 && cell <= 0x73: // хирагана
+		return 0x3041 + rune(cell-0x21)
+	case row == 0x25 && cell >= 0x21 && cell <= 0x76: // катакана
+		return 0x30A1 + rune(cell-0x21)
+	case row == 0x27 && cell >= 0x21 && cell <= 0x41: // кириллица, заглавные
+		return cyrillicRune(cell-0x21, 'А', 'Ё')
+	case row == 0x27 && cell >= 0x51 && cell <= 0x71: // кириллица, строчные
+		return cyrillicRune(cell-0x51, 'а', 'ё')
+	}
+	return utf8.RuneError
+}
+
+// cyrillicRune возвращает букву по номеру в алфавите JIS X 0208, где Ё стоит после Е
+func cyrillicRune(index byte, first, yo rune) rune {
+	switch {
+	case index < 6:
+		return first + rune(index)
+	case index == 6:
+		return yo
+	default:
+		return first + rune(index) - 1
+	}
+}
+
+// trimNulls отрезает данные по первому нулевому байту
+func trimNulls(data []byte) []byte {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return data[:i]
+	}
+	return data
+}
@@ -0,0 +1,18 @@
+package models
+
+// AnomalyType - вид подозрительного показания
+type AnomalyType string
+
+const (
+	AnomalyRollback AnomalyType = "rollback" // показание меньше предыдущего или больше следующего
+	AnomalyOutlier  AnomalyType = "outlier"  // расход сильно отличается от обычного для счетчика
+	AnomalyRepeated AnomalyType = "repeated" // то же показание, что и в другом месяце
+)
+
+// Anomaly - отметка о подозрительном показании
+type Anomaly struct {
+	Type     AnomalyType `json:"type"`
+	Message  string      `json:"message"`
+	Related  string      `json:"related,omitempty"`  // фото, с показанием которого сравнивали
+	Register string      `json:"register,omitempty"` // регистр показания; пусто для показания без регистра
+}
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MeterType - вид учета счетчика
+type MeterType string
+
+const (
+	MeterWater     MeterType = "water"
+	MeterHotWater  MeterType = "hot_water"
+	MeterColdWater MeterType = "cold_water"
+	MeterGas       MeterType = "gas"
+	MeterElectric  MeterType = "electric"
+	MeterHeat      MeterType = "heat"
+)
+
+// MeterTypes - все допустимые виды учета
+var MeterTypes = []MeterType{MeterWater, MeterHotWater, MeterColdWater, MeterGas, MeterElectric, MeterHeat}
+
+// InstallDateLayout - формат даты установки счетчика
+const InstallDateLayout = "2006-01-02"
+
+// Meter - счетчик из реестра
+type Meter struct {
+	SerialNumber string    `json:"serialNumber"`
+	Type         MeterType `json:"type"`
+	Address      string    `json:"address,omitempty"`
+	Account      string    `json:"account,omitempty"`     // лицевой счет
+	InstallDate  string    `json:"installDate,omitempty"` // ГГГГ-ММ-ДД
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+// Validate проверяет поля счетчика и приводит их к каноническому виду
+func (m *Meter) Validate() error {
+	m.SerialNumber = strings.TrimSpace(m.SerialNumber)
+	m.Address = strings.TrimSpace(m.Address)
+	m.Account = strings.TrimSpace(m.Account)
+	m.InstallDate = strings.TrimSpace(m.InstallDate)
+	m.Type = MeterType(strings.ToLower(strings.TrimSpace(string(m.Type))))
+
+	if m.SerialNumber == "" {
+		return fmt.Errorf("serialNumber is required")
+	}
+	if !m.Type.Valid() {
+		return fmt.Errorf("type must be one of %s", joinMeterTypes())
+	}
+	if m.InstallDate != "" {
+		if _, err := time.Parse(InstallDateLayout, m.InstallDate); err != nil {
+			return fmt.Errorf("installDate must be in YYYY-MM-DD format")
+		}
+	}
+	return nil
+}
+
+// Valid проверяет, что вид учета известен
+func (t MeterType) Valid() bool {
+	for _, known := range MeterTypes {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+func joinMeterTypes() string {
+	names := make([]string, len(MeterTypes))
+	for i, t := range MeterTypes {
+		names[i] = string(t)
+	}
+	return strings.Join(names, ", ")
+}
@@ -6,31 +6,58 @@ import "time"
 type SyncStatus string
 
 const (
-	StatusWaiting  SyncStatus = "waiting"
-	StatusReady    SyncStatus = "ready"
-	StatusSyncing  SyncStatus = "syncing"
+	StatusWaiting   SyncStatus = "waiting"
+	StatusReady     SyncStatus = "ready"
+	StatusSyncing   SyncStatus = "syncing"
 	StatusCompleted SyncStatus = "completed"
-	StatusError    SyncStatus = "error"
+	StatusError     SyncStatus = "error"
 )
 
+// Результаты обработки одного файла
+const (
+	FileAccepted  = "accepted"
+	FileDuplicate = "duplicate"
+	FileError     = "error"
+)
+
+// FileResult описывает результат обработки одного файла сессии
+type FileResult struct {
+	Name          string `json:"name"`
+	Status        string `json:"status"`
+	CounterNumber string `json:"counterNumber,omitempty"`
+	Hash          string `json:"hash,omitempty"`
+	Path          string `json:"path,omitempty"`
+	Reason        string `json:"reason,omitempty"`
+	Error         string `json:"error,omitempty"`
+
+	// Предупреждения о принятом файле, например о счетчике не из реестра
+	Warnings []string `json:"warnings,omitempty"`
+	// Отметки о подозрительном показании
+	Anomalies []Anomaly `json:"anomalies,omitempty"`
+}
+
 // Session представляет сессию синхронизации
 type Session struct {
-	Token       string     `json:"token"`
-	Status      SyncStatus `json:"status"`
-	Total       int        `json:"total"`
-	Uploaded    int        `json:"uploaded"`
-	Skipped     int        `json:"skipped"`
-	StartTime   time.Time  `json:"startTime"`
-	LastUpdate  time.Time  `json:"lastUpdate"`
-	CurrentFile string     `json:"currentFile,omitempty"`
-	Errors      []string   `json:"errors,omitempty"`
+	Token       string       `json:"token"`
+	Status      SyncStatus   `json:"status"`
+	Total       int          `json:"total"`
+	Uploaded    int          `json:"uploaded"`
+	Skipped     int          `json:"skipped"`
+	StartTime   time.Time    `json:"startTime"`
+	LastUpdate  time.Time    `json:"lastUpdate"`
+	CurrentFile string       `json:"currentFile,omitempty"`
+	Errors      []string     `json:"errors,omitempty"`
+	LastResult  *FileResult  `json:"lastResult,omitempty"` // результат обработки последнего файла
+	Received    []FileResult `json:"received,omitempty"`   // принятые и пропущенные файлы сессии
+	Secret      string       `json:"secret,omitempty"`     // секрет для HMAC подписи запросов устройства
 }
 
 // NewSession создает новую сессию
-func NewSession(token string) *Session {
+func NewSession(token string, secret string) *Session {
 	now := time.Now()
 	return &Session{
 		Token:      token,
+		Secret:     secret,
 		Status:     StatusWaiting,
 		Total:      0,
 		Uploaded:   0,
@@ -64,5 +91,3 @@ func (s *Session) GetEstimatedTimeRemaining() int {
 	remaining := float64(s.Total-s.Uploaded-s.Skipped) * avgTimePerFile
 	return int(remaining)
 }
-
-
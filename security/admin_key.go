@@ -0,0 +1,48 @@
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// AdminKeyFile - имя файла с ключом администратора в папке индекса
+const AdminKeyFile = "admin.key"
+
+// LoadOrCreateAdminKey читает ключ администратора из папки индекса,
+// а если его нет - генерирует и сохраняет новый. Второе значение равно true, если ключ создан.
+func LoadOrCreateAdminKey(indexDir string) (string, bool, error) {
+	path := filepath.Join(indexDir, AdminKeyFile)
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key := strings.TrimSpace(string(data))
+		if key != "" {
+			return key, false, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return "", false, fmt.Errorf("failed to read admin key: %w", err)
+	}
+
+	key, err := GenerateSecret()
+	if err != nil {
+		return "", false, err
+	}
+	if err := os.WriteFile(path, []byte(key+"\n"), 0600); err != nil {
+		return "", false, fmt.Errorf("failed to save admin key: %w", err)
+	}
+	return key, true, nil
+}
+
+// AdminCookieValue возвращает значение cookie администратора для галереи. В cookie хранится
+// не сам ключ, а производное от него значение: оно не годится для заголовка X-Admin-Key
+// и перестает действовать при смене ключа.
+func AdminCookieValue(adminKey string) string {
+	mac := hmac.New(sha256.New, []byte(adminKey))
+	mac.Write([]byte("photo-sync admin cookie"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
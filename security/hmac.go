@@ -0,0 +1,55 @@
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// EmptySHA256 - SHA256 пустого тела запроса
+const EmptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// GenerateSecret генерирует случайный секрет (32 байта в hex)
+func GenerateSecret() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate secret: %w", err)
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// CanonicalRequest формирует строку, которую подписывает устройство:
+// метод, путь, токен сессии, время (unix секунды), SHA256 содержимого и подписанные
+// поля формы ("имя=значение", для /sync), каждое с новой строки
+func CanonicalRequest(method, path, token, timestamp, contentSHA256 string, fields ...string) string {
+	lines := []string{
+		strings.ToUpper(method),
+		path,
+		token,
+		timestamp,
+		strings.ToLower(contentSHA256),
+	}
+	return strings.Join(append(lines, fields...), "\n")
+}
+
+// Sign возвращает HMAC-SHA256 подпись запроса в hex
+func Sign(secret, method, path, token, timestamp, contentSHA256 string, fields ...string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(CanonicalRequest(method, path, token, timestamp, contentSHA256, fields...)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature проверяет подпись запроса за постоянное время
+func VerifySignature(secret, signature, method, path, token, timestamp, contentSHA256 string, fields ...string) bool {
+	expected := Sign(secret, method, path, token, timestamp, contentSHA256, fields...)
+	return hmac.Equal([]byte(expected), []byte(strings.ToLower(signature)))
+}
+
+// EqualKeys сравнивает ключи за постоянное время
+func EqualKeys(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,241 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"photo-sync-server/metadata"
+	"photo-sync-server/models"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// anomalyMinHistory - сколько интервалов между показаниями нужно, чтобы судить об обычном расходе
+	anomalyMinHistory = 4
+	// anomalyMinDays - интервалы короче суток (пересъемка) не учитываются в расходе
+	anomalyMinDays = 1.0
+	// anomalyThreshold - порог модифицированной z-оценки (Iglewicz, Hoaglin)
+	anomalyThreshold = 3.5
+)
+
+// DetectAnomalies проверяет показание value регистра register на дату date по истории
+// показаний этого регистра: показание меньше предыдущего (или больше следующего),
+// расход в сутки далеко за пределами обычного для счетчика и повтор показания другого месяца.
+func DetectAnomalies(history []*PhotoInfo, register string, date time.Time, value float64) []models.Anomaly {
+	points := ReadingSeries(history, register)
+	var anomalies []models.Anomaly
+
+	// Соседние показания по дате: фото могут прийти не в порядке съемки
+	var prev, next *ReadingPoint
+	for i := range points {
+		if points[i].Date.After(date) {
+			next = &points[i]
+			break
+		}
+		prev = &points[i]
+	}
+
+	if prev != nil && roundReading(value-prev.Value) < 0 {
+		anomalies = append(anomalies, models.Anomaly{
+			Type:    models.AnomalyRollback,
+			Message: fmt.Sprintf("reading %s is lower than the previous reading %s", formatReading(value), formatReading(prev.Value)),
+			Related: prev.Path,
+		})
+	}
+	if next != nil && roundReading(value-next.Value) > 0 {
+		anomalies = append(anomalies, models.Anomaly{
+			Type:    models.AnomalyRollback,
+			Message: fmt.Sprintf("reading %s is higher than the next reading %s", formatReading(value), formatReading(next.Value)),
+			Related: next.Path,
+		})
+	}
+
+	if prev != nil {
+		if anomaly, ok := consumptionOutlier(points, prev, date, value); ok {
+			anomalies = append(anomalies, anomaly)
+		}
+	}
+
+	if repeated := repeatedReading(points, date, value); repeated != nil {
+		anomalies = append(anomalies, models.Anomaly{
+			Type:    models.AnomalyRepeated,
+			Message: fmt.Sprintf("reading %s repeats the reading of %s", formatReading(value), repeated.Date.Format(ReadingMonthLayout)),
+			Related: repeated.Path,
+		})
+	}
+
+	if register != metadata.DefaultRegister {
+		for i := range anomalies {
+			anomalies[i].Register = register
+			anomalies[i].Message = "register " + register + ": " + anomalies[i].Message
+		}
+	}
+	return anomalies
+}
+
+// RecheckAnomalies заново проверяет показания регистров registers у всех фото счетчика
+// и сохраняет изменившиеся отметки. Вызывается после добавления фото: более старое фото,
+// пришедшее позже, может сделать следующее показание откатом или снять отметку с него.
+// Возвращает число фото, у которых изменились отметки.
+func (idx *Indexer) RecheckAnomalies(counterNumber string, registers []string) (int, error) {
+	if len(registers) == 0 {
+		return 0, nil
+	}
+	prefix := append([]byte(NormalizeCounterNumber(counterNumber)), 0)
+
+	changed := 0
+	err := idx.db.Update(func(tx *bolt.Tx) error {
+		photosBucket := tx.Bucket(bucketPhotos)
+		var ids [][]byte
+		var records []*photoRecord
+		cursor := tx.Bucket(bucketByCounter).Cursor()
+		for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+			id := append([]byte(nil), k[len(k)-8:]...)
+			if record := loadPhoto(photosBucket, id); record != nil {
+				ids = append(ids, id)
+				records = append(records, record)
+			}
+		}
+
+		history := make([]*PhotoInfo, len(records))
+		for i, record := range records {
+			history[i] = &record.PhotoInfo
+		}
+
+		checked := make(map[string]bool, len(registers))
+		for _, register := range registers {
+			checked[register] = true
+		}
+
+		for i, record := range records {
+			// Фото сверяется с остальными фото счетчика, как при приеме
+			others := make([]*PhotoInfo, 0, len(history)-1)
+			others = append(append(others, history[:i]...), history[i+1:]...)
+
+			var anomalies []models.Anomaly
+			for _, anomaly := range record.Anomalies {
+				if !checked[anomalyRegister(anomaly)] {
+					anomalies = append(anomalies, anomaly)
+				}
+			}
+			for _, register := range registers {
+				if value, ok := record.RegisterReading(register); ok {
+					anomalies = append(anomalies, DetectAnomalies(others, register, record.Date, value)...)
+				}
+			}
+
+			if sameAnomalies(anomalies, record.Anomalies) {
+				continue
+			}
+			record.Anomalies = anomalies
+			if err := putPhoto(photosBucket, ids[i], record); err != nil {
+				return err
+			}
+			changed++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to save index: %w", err)
+	}
+	return changed, nil
+}
+
+// sameAnomalies сравнивает списки отметок с учетом порядка
+func sameAnomalies(a, b []models.Anomaly) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// anomalyRegister возвращает регистр отметки (metadata.DefaultRegister для показания без регистра)
+func anomalyRegister(anomaly models.Anomaly) string {
+	if anomaly.Register == "" {
+		return metadata.DefaultRegister
+	}
+	return anomaly.Register
+}
+
+// repeatedReading ищет то же показание в другом месяце. Пересъемка в том же месяце
+// дает то же показание, это нормально, и такое показание не отмечается.
+func repeatedReading(points []ReadingPoint, date time.Time, value float64) *ReadingPoint {
+	month := date.Format(ReadingMonthLayout)
+	var repeated *ReadingPoint
+	for i := range points {
+		if roundReading(points[i].Value-value) != 0 {
+			continue
+		}
+		if points[i].Date.Format(ReadingMonthLayout) == month {
+			return nil
+		}
+		if repeated == nil {
+			repeated = &points[i]
+		}
+	}
+	return repeated
+}
+
+// consumptionOutlier сравнивает расход в сутки с предыдущего показания с историей
+// расхода счетчика по медиане и медианному абсолютному отклонению
+func consumptionOutlier(points []ReadingPoint, prev *ReadingPoint, date time.Time, value float64) (models.Anomaly, bool) {
+	days := date.Sub(prev.Date).Hours() / 24
+	delta := value - prev.Value
+	if days < anomalyMinDays || delta < 0 {
+		return models.Anomaly{}, false
+	}
+
+	var rates []float64
+	for _, point := range points {
+		if point.Delta != nil && *point.Delta >= 0 && point.Days >= anomalyMinDays {
+			rates = append(rates, *point.Delta/point.Days)
+		}
+	}
+	if len(rates) < anomalyMinHistory {
+		return models.Anomaly{}, false
+	}
+
+	median := medianOf(rates)
+	deviations := make([]float64, len(rates))
+	for i, rate := range rates {
+		deviations[i] = math.Abs(rate - median)
+	}
+	// При почти постоянном расходе MAD близко к нулю, поэтому отклонения меньше 10% медианы не считаются
+	scale := math.Max(1.4826*medianOf(deviations), 0.1*median)
+
+	rate := delta / days
+	if roundReading(rate-median) == 0 || (scale > 0 && math.Abs(rate-median)/scale <= anomalyThreshold) {
+		return models.Anomaly{}, false
+	}
+	return models.Anomaly{
+		Type: models.AnomalyOutlier,
+		Message: fmt.Sprintf("consumption %s per day since the previous reading is far from the usual %s per day",
+			strconv.FormatFloat(rate, 'f', 3, 64), strconv.FormatFloat(median, 'f', 3, 64)),
+		Related: prev.Path,
+	}, true
+}
+
+// medianOf возвращает медиану, не меняя порядок values
+func medianOf(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
+
+func formatReading(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
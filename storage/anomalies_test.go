@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"photo-sync-server/models"
+)
+
+func addReading(t *testing.T, indexer *Indexer, counter string, date time.Time, value float64) {
+	t.Helper()
+	photo := &PhotoInfo{
+		Path: fmt.Sprintf("%s/%s.jpg", counter, date.Format("20060102")),
+		Date: date,
+	}
+	photo.SetReadings(map[string]float64{"main": value})
+	photo.Anomalies = DetectAnomalies(indexer.GetPhotosByCounter(counter), "main", date, value)
+	if err := indexer.AddPhoto(counter, photo); err != nil {
+		t.Fatalf("AddPhoto: %v", err)
+	}
+	if _, err := indexer.RecheckAnomalies(counter, []string{"main"}); err != nil {
+		t.Fatalf("RecheckAnomalies: %v", err)
+	}
+}
+
+func anomalyTypes(indexer *Indexer, path string) []models.AnomalyType {
+	var types []models.AnomalyType
+	for _, anomaly := range indexer.FindByPath(path).Anomalies {
+		types = append(types, anomaly.Type)
+	}
+	return types
+}
+
+func TestRecheckAnomaliesOutOfOrder(t *testing.T) {
+	indexer := newTestIndexer(t)
+	jan := time.Date(2025, 1, 10, 10, 0, 0, 0, time.UTC)
+	feb := jan.AddDate(0, 1, 0)
+	mar := jan.AddDate(0, 2, 0)
+
+	addReading(t, indexer, "0011067", jan, 100)
+	addReading(t, indexer, "0011067", mar, 120)
+	if got := anomalyTypes(indexer, "0011067/20250310.jpg"); len(got) != 0 {
+		t.Fatalf("march anomalies before february = %v", got)
+	}
+
+	// Февральское фото пришло последним: его показание больше мартовского,
+	// отметку получают оба фото
+	addReading(t, indexer, "0011067", feb, 150)
+	if got := anomalyTypes(indexer, "0011067/20250210.jpg"); fmt.Sprint(got) != "[rollback]" {
+		t.Errorf("february anomalies = %v, want [rollback]", got)
+	}
+	if got := anomalyTypes(indexer, "0011067/20250310.jpg"); fmt.Sprint(got) != "[rollback]" {
+		t.Errorf("march anomalies = %v, want [rollback]", got)
+	}
+	if got := anomalyTypes(indexer, "0011067/20250110.jpg"); len(got) != 0 {
+		t.Errorf("january anomalies = %v, want none", got)
+	}
+}
+
+func TestRecheckAnomaliesOtherCounter(t *testing.T) {
+	indexer := newTestIndexer(t)
+	jan := time.Date(2025, 1, 10, 10, 0, 0, 0, time.UTC)
+
+	// Мартовское показание меньше январского - откат
+	addReading(t, indexer, "0011067", jan, 100)
+	addReading(t, indexer, "0011067", jan.AddDate(0, 2, 0), 90)
+	if got := anomalyTypes(indexer, "0011067/20250310.jpg"); fmt.Sprint(got) != "[rollback]" {
+		t.Fatalf("march anomalies = %v, want [rollback]", got)
+	}
+
+	// Фото другого счетчика не меняет отметки этого
+	addReading(t, indexer, "0022000", jan, 5)
+	if got := anomalyTypes(indexer, "0011067/20250310.jpg"); fmt.Sprint(got) != "[rollback]" {
+		t.Errorf("march anomalies after another counter = %v, want [rollback]", got)
+	}
+}
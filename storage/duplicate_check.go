@@ -26,6 +26,56 @@ func NewDuplicateCheck() *DuplicateCheck {
 	}
 }
 
+// DuplicateLoadReport содержит итоги загрузки базы хешей из индекса
+type DuplicateLoadReport struct {
+	Loaded       int // загружено хешей
+	WithoutHash  int // записей индекса без хеша
+	MissingFiles int // записей, файлы которых удалены с диска
+}
+
+// LoadFromIndexer заполняет базу хешей фотографиями из индекса.
+// Записи, файлы которых удалены с диска, пропускаются, чтобы такие фото можно было загрузить заново.
+func (dc *DuplicateCheck) LoadFromIndexer(indexer *Indexer, fileManager *FileManager) DuplicateLoadReport {
+	var report DuplicateLoadReport
+	hashDB := make(map[string]*FileHashInfo)
+
+	indexer.ForEachPhoto(func(counterNumber string, photo *PhotoInfo) {
+		if photo.Hash == "" {
+			report.WithoutHash++
+			return
+		}
+		if !fileManager.FileExists(photo.Path) {
+			report.MissingFiles++
+			return
+		}
+		if _, exists := hashDB[photo.Hash]; !exists {
+			report.Loaded++
+		}
+		hashDB[photo.Hash] = &FileHashInfo{
+			Hash: photo.Hash,
+			Size: photo.Size,
+			Date: photo.Date,
+			Path: photo.Path,
+		}
+	})
+
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	for hash, info := range hashDB {
+		dc.hashDB[hash] = info
+	}
+	return report
+}
+
+// Count возвращает количество хешей в базе
+func (dc *DuplicateCheck) Count() int {
+	dc.mu.RLock()
+	defer dc.mu.RUnlock()
+
+	return len(dc.hashDB)
+}
+
 // CheckDuplicate проверяет, является ли файл дубликатом
 func (dc *DuplicateCheck) CheckDuplicate(fileHash string, size int64, counterNumber string, dateTaken time.Time, indexer *Indexer) (*FileHashInfo, string) {
 	dc.mu.RLock()
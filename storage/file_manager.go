@@ -1,18 +1,25 @@
 package storage
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
+// IncomingDirName - папка внутри baseDir для файлов, которые еще принимаются
+const IncomingDirName = ".incoming"
+
 // FileManager управляет сохранением файлов
 type FileManager struct {
 	baseDir string
+	layout  *Layout
 }
 
 // BaseDir возвращает базовую директорию
@@ -20,78 +27,296 @@ func (fm *FileManager) BaseDir() string {
 	return fm.baseDir
 }
 
-// NewFileManager создает новый менеджер файлов
-func NewFileManager(baseDir string) *FileManager {
-	return &FileManager{
+// Layout возвращает шаблон раскладки файлов по папкам
+func (fm *FileManager) Layout() *Layout {
+	return fm.layout
+}
+
+// NewFileManager создает новый менеджер файлов с указанным шаблоном раскладки
+func NewFileManager(baseDir string, layout *Layout) *FileManager {
+	fm := &FileManager{
 		baseDir: baseDir,
+		layout:  layout,
+	}
+
+	// Удаляем остатки загрузок, прерванных при прошлом запуске
+	fm.cleanIncoming()
+
+	return fm
+}
+
+// IncomingFile описывает файл, принятый потоком во временную папку, но еще не сохраненный
+type IncomingFile struct {
+	Path string // путь к временному файлу
+	Hash string // SHA256 хеш содержимого
+	Size int64  // размер в байтах
+}
+
+// SaveFile сохраняет файл из памяти (обертка над SaveStream)
+func (fm *FileManager) SaveFile(filename string, counterNumber string, data []byte, dateTaken time.Time) (string, error) {
+	relPath, _, err := fm.SaveStream(filename, counterNumber, bytes.NewReader(data), dateTaken)
+	return relPath, err
+}
+
+// SaveStream сохраняет файл из потока, не держа его целиком в памяти.
+// Возвращает относительный путь и SHA256 хеш сохраненного файла.
+func (fm *FileManager) SaveStream(filename string, counterNumber string, r io.Reader, dateTaken time.Time) (string, *IncomingFile, error) {
+	incoming, err := fm.ReceiveStream(r)
+	if err != nil {
+		return "", nil, err
+	}
+
+	relPath, err := fm.CommitIncoming(incoming, filename, counterNumber, dateTaken)
+	if err != nil {
+		fm.DiscardIncoming(incoming)
+		return "", nil, err
 	}
+
+	return relPath, incoming, nil
 }
 
-// SaveFile сохраняет файл в структурированную папку по дате
-func (fm *FileManager) SaveFile(filename string, data []byte, dateTaken time.Time) (string, error) {
+// ReceiveStream записывает поток во временный файл, одновременно вычисляя SHA256.
+// Временный файл лежит внутри baseDir, поэтому CommitIncoming переносит его атомарным переименованием.
+func (fm *FileManager) ReceiveStream(r io.Reader) (*IncomingFile, error) {
+	incomingDir := filepath.Join(fm.baseDir, IncomingDirName)
+	if err := os.MkdirAll(incomingDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create incoming directory: %w", err)
+	}
+
+	file, err := os.CreateTemp(incomingDir, "upload-*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("cannot write to directory %s: %w", incomingDir, err)
+	}
+
+	// CreateTemp создает файл с правами 0600, сохраненные фото должны быть доступны как обычно
+	err = file.Chmod(0644)
+
+	hash := sha256.New()
+	var size int64
+	if err == nil {
+		size, err = io.Copy(io.MultiWriter(file, hash), r)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return nil, fmt.Errorf("failed to write file: %w", err)
+	}
+
+	return &IncomingFile{
+		Path: file.Name(),
+		Hash: hex.EncodeToString(hash.Sum(nil)),
+		Size: size,
+	}, nil
+}
+
+// AdoptIncoming принимает уже записанный файл (например, собранную возобновляемую загрузку)
+// без копирования: файл переносится во временную папку baseDir и дальше сохраняется
+// через CommitIncoming. Если переименовать файл нельзя (папка на другом диске),
+// он копируется через ReceiveStream. В любом случае исходного файла после вызова нет.
+func (fm *FileManager) AdoptIncoming(path string) (*IncomingFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	incomingDir := filepath.Join(fm.baseDir, IncomingDirName)
+	if err := os.MkdirAll(incomingDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create incoming directory: %w", err)
+	}
+	reserved, err := os.CreateTemp(incomingDir, "upload-*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("cannot write to directory %s: %w", incomingDir, err)
+	}
+	reserved.Close()
+
+	if err := os.Rename(path, reserved.Name()); err == nil {
+		os.Chmod(reserved.Name(), 0644)
+		return &IncomingFile{Path: reserved.Name(), Hash: hex.EncodeToString(hash.Sum(nil)), Size: size}, nil
+	}
+	os.Remove(reserved.Name())
+
+	file, err = os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	incoming, err := fm.ReceiveStream(file)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(path)
+	return incoming, nil
+}
+
+// CommitIncoming атомарно переносит принятый файл на место, заданное шаблоном раскладки,
+// и возвращает относительный путь. Если файл с таким именем уже есть, к имени добавляется _2, _3 и т.д.
+func (fm *FileManager) CommitIncoming(incoming *IncomingFile, filename string, counterNumber string, dateTaken time.Time) (string, error) {
 	// Если дата равна эпохе Unix (1970-01-01), используем текущую дату
 	if dateTaken.Unix() == 0 || dateTaken.Year() < 2000 {
 		dateTaken = time.Now()
 	}
 
-	// Убеждаемся, что базовая директория существует
-	_, err := os.Stat(fm.baseDir)
-	if os.IsNotExist(err) {
-		if err := os.MkdirAll(fm.baseDir, 0755); err != nil {
-			return "", fmt.Errorf("failed to create base directory: %w", err)
+	ext := filepath.Ext(filename)
+	target := fm.layout.Render(LayoutVars{
+		Counter: counterNumber,
+		Date:    dateTaken,
+		Name:    strings.TrimSuffix(filepath.Base(filename), ext),
+		Ext:     ext,
+		Hash:    incoming.Hash,
+	})
+
+	return fm.placeFile(incoming.Path, target, "")
+}
+
+// RelocateFile переносит уже сохраненный файл на место, заданное текущим шаблоном раскладки.
+// Возвращает новый относительный путь (совпадает с relPath, если файл уже на своем месте).
+func (fm *FileManager) RelocateFile(relPath string, counterNumber string, dateTaken time.Time, hash string) (string, error) {
+	target := fm.relocationTarget(relPath, counterNumber, dateTaken, hash)
+
+	newRelPath, err := fm.placeFile(filepath.Join(fm.baseDir, relPath), target, relPath)
+	if err != nil {
+		return "", err
+	}
+	if newRelPath != relPath {
+		fm.removeEmptyParents(filepath.Dir(filepath.Join(fm.baseDir, relPath)))
+	}
+	return newRelPath, nil
+}
+
+// PlanRelocation возвращает путь, куда RelocateFile перенес бы файл, ничего не меняя на диске
+func (fm *FileManager) PlanRelocation(relPath string, counterNumber string, dateTaken time.Time, hash string) string {
+	target := fm.relocationTarget(relPath, counterNumber, dateTaken, hash)
+	for n := 1; ; n++ {
+		candidate := target
+		if n > 1 {
+			candidate = withSuffix(target, n)
+		}
+		candidatePath := filepath.FromSlash(candidate)
+		if candidatePath == filepath.Clean(relPath) || !fm.FileExists(candidatePath) {
+			return candidatePath
 		}
-	} else if err != nil {
-		return "", fmt.Errorf("failed to check base directory: %w", err)
 	}
+}
 
-	// Временно сохраняем все файлы напрямую в базовую директорию без подпапок
-	dir := fm.baseDir
-	
-	// Нормализуем путь для Windows
-	dir = filepath.Clean(dir)
+// relocationTarget строит путь по шаблону для уже сохраненного файла
+func (fm *FileManager) relocationTarget(relPath string, counterNumber string, dateTaken time.Time, hash string) string {
+	ext := filepath.Ext(relPath)
+	return fm.layout.Render(LayoutVars{
+		Counter: counterNumber,
+		Date:    dateTaken,
+		Name:    strings.TrimSuffix(filepath.Base(relPath), ext),
+		Ext:     ext,
+		Hash:    hash,
+	})
+}
 
-	// Проверяем права на запись в директорию
-	testFile := filepath.Join(dir, ".write_test")
-	if err := os.WriteFile(testFile, []byte("test"), 0644); err != nil {
-		return "", fmt.Errorf("cannot write to directory %s: %w", dir, err)
+// placeFile переносит файл src по относительному пути target (с разделителем /), не перезаписывая
+// существующие файлы. Конфликты разрешаются детерминированно: target, target_2, target_3...
+// Если один из кандидатов совпадает с currentRelPath, файл уже на месте и не переносится.
+func (fm *FileManager) placeFile(src string, target string, currentRelPath string) (string, error) {
+	for n := 1; ; n++ {
+		candidate := target
+		if n > 1 {
+			candidate = withSuffix(target, n)
+		}
+		relPath := filepath.FromSlash(candidate)
+		if currentRelPath != "" && relPath == filepath.Clean(currentRelPath) {
+			return relPath, nil
+		}
+
+		fullPath := filepath.Join(fm.baseDir, relPath)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			return "", fmt.Errorf("failed to create directory: %w", err)
+		}
+
+		err := moveNoReplace(src, fullPath)
+		if err == nil {
+			return relPath, nil
+		}
+		if !os.IsExist(err) {
+			return "", fmt.Errorf("failed to move file into place: %w", err)
+		}
 	}
-	os.Remove(testFile) // Удаляем тестовый файл
+}
 
-	// Формируем имя файла: используем оригинальное имя с уникальным суффиксом
-	ext := filepath.Ext(filename)
-	if ext == "" {
-		ext = ".jpg"
+// moveNoReplace атомарно переносит файл, возвращая os.ErrExist, если dst уже существует.
+// Используется жесткая ссылка; если ФС их не поддерживает, делается проверка и переименование.
+func moveNoReplace(src, dst string) error {
+	err := os.Link(src, dst)
+	if err == nil {
+		return os.Remove(src)
 	}
-	
-	// Используем оригинальное имя файла, но добавляем уникальный суффикс для избежания конфликтов
-	baseName := filename[:len(filename)-len(ext)]
-	timestamp := time.Now().UnixNano() // Используем наносекунды для уникальности
-	newFilename := fmt.Sprintf("%s_%d%s", baseName, timestamp, ext)
-	
-	fullPath := filepath.Join(dir, newFilename)
+	if os.IsExist(err) {
+		return err
+	}
+
+	if _, statErr := os.Stat(dst); statErr == nil {
+		return os.ErrExist
+	}
+	return os.Rename(src, dst)
+}
 
-	// Сохраняем файл используя os.Create для лучшей совместимости с Windows
-	file, err := os.Create(fullPath)
+// removeEmptyParents удаляет опустевшие папки вверх по дереву, не выходя за baseDir
+func (fm *FileManager) removeEmptyParents(dir string) {
+	base := filepath.Clean(fm.baseDir)
+	for dir = filepath.Clean(dir); dir != base && strings.HasPrefix(dir, base); dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+	}
+}
+
+// RemoveFile удаляет сохраненное фото и опустевшие папки (например, если фото не удалось проиндексировать)
+func (fm *FileManager) RemoveFile(relPath string) error {
+	fullPath, _, err := fm.ResolvePath(filepath.ToSlash(relPath))
 	if err != nil {
-		return "", fmt.Errorf("failed to create file: %w", err)
+		return err
 	}
-	defer file.Close()
-	
-	if _, err := file.Write(data); err != nil {
-		return "", fmt.Errorf("failed to write file: %w", err)
+	if err := os.Remove(fullPath); err != nil {
+		return err
 	}
-	
-	if err := file.Sync(); err != nil {
-		// Не критично, продолжаем
+	fm.removeEmptyParents(filepath.Dir(fullPath))
+	return nil
+}
+
+// DiscardIncoming удаляет принятый файл, который не нужно сохранять (например, дубликат)
+func (fm *FileManager) DiscardIncoming(incoming *IncomingFile) {
+	if incoming != nil {
+		os.Remove(incoming.Path)
 	}
+}
+
+// OpenIncoming открывает принятый файл для чтения (например, для разбора EXIF)
+func (fm *FileManager) OpenIncoming(incoming *IncomingFile) (*os.File, error) {
+	return os.Open(incoming.Path)
+}
 
-	// Возвращаем относительный путь
-	relPath, err := filepath.Rel(fm.baseDir, fullPath)
+// cleanIncoming удаляет временные файлы, брошенные прерванными загрузками
+func (fm *FileManager) cleanIncoming() {
+	incomingDir := filepath.Join(fm.baseDir, IncomingDirName)
+	entries, err := os.ReadDir(incomingDir)
 	if err != nil {
-		return "", fmt.Errorf("failed to get relative path: %w", err)
+		return
 	}
 
-	return relPath, nil
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < time.Hour {
+			continue
+		}
+		os.Remove(filepath.Join(incomingDir, entry.Name()))
+	}
 }
 
 // CalculateHash вычисляет SHA256 хеш файла
@@ -100,6 +325,56 @@ func (fm *FileManager) CalculateHash(data []byte) string {
 	return hex.EncodeToString(hash[:])
 }
 
+// ErrInvalidPath возвращается для путей, выходящих за пределы папки с фото
+var ErrInvalidPath = errors.New("invalid photo path")
+
+// ResolvePath проверяет относительный путь, пришедший извне, и возвращает полный путь к файлу
+// вместе с путем относительно baseDir. Отклоняются абсолютные пути, переходы "..",
+// служебные папки (.index, .incoming), имена устройств Windows, альтернативные потоки NTFS,
+// директории и символические ссылки, ведущие за пределы baseDir.
+func (fm *FileManager) ResolvePath(relPath string) (string, string, error) {
+	relPath = strings.TrimPrefix(relPath, "/")
+	if relPath == "" || strings.ContainsAny(relPath, "\x00:") {
+		return "", "", ErrInvalidPath
+	}
+
+	relPath = filepath.FromSlash(relPath)
+	if !filepath.IsLocal(relPath) {
+		return "", "", ErrInvalidPath
+	}
+
+	cleaned := filepath.Clean(relPath)
+	for _, part := range strings.Split(cleaned, string(filepath.Separator)) {
+		if strings.HasPrefix(part, ".") {
+			return "", "", ErrInvalidPath
+		}
+	}
+
+	fullPath := filepath.Join(fm.baseDir, cleaned)
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return "", "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", "", ErrInvalidPath
+	}
+
+	// Ссылка внутри папки с фото может указывать куда угодно, сверяем настоящий путь
+	realBase, err := filepath.EvalSymlinks(fm.baseDir)
+	if err != nil {
+		return "", "", err
+	}
+	realPath, err := filepath.EvalSymlinks(fullPath)
+	if err != nil {
+		return "", "", err
+	}
+	if rel, err := filepath.Rel(realBase, realPath); err != nil || !filepath.IsLocal(rel) {
+		return "", "", ErrInvalidPath
+	}
+
+	return fullPath, cleaned, nil
+}
+
 // FileExists проверяет существование файла
 func (fm *FileManager) FileExists(relPath string) bool {
 	fullPath := filepath.Join(fm.baseDir, relPath)
@@ -113,6 +388,16 @@ func (fm *FileManager) GetFileInfo(relPath string) (os.FileInfo, error) {
 	return os.Stat(fullPath)
 }
 
+// OpenFile открывает сохраненное фото для чтения. Путь относительно baseDir проверяется
+// так же, как в ResolvePath.
+func (fm *FileManager) OpenFile(relPath string) (*os.File, error) {
+	fullPath, _, err := fm.ResolvePath(filepath.ToSlash(relPath))
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(fullPath)
+}
+
 // ReadFile читает файл
 func (fm *FileManager) ReadFile(relPath string) ([]byte, error) {
 	fullPath := filepath.Join(fm.baseDir, relPath)
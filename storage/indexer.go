@@ -1,20 +1,50 @@
 package storage
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
-	"sync"
 	"time"
+
+	"photo-sync-server/metadata"
+	"photo-sync-server/models"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// IndexFile - файл базы индекса в папке индекса
+const IndexFile = "photo_index.db"
+
+// LegacyIndexFile - JSON индекс прежних версий, импортируется при первом запуске
+const LegacyIndexFile = "photo_index.json"
+
+// Бакеты базы индекса. Вторичные индексы хранят только ключи, значение - ID фото.
+var (
+	bucketPhotos    = []byte("photos")     // id -> photoRecord (JSON)
+	bucketByHash    = []byte("by_hash")    // hash \x00 id -> nil
+	bucketByCounter = []byte("by_counter") // counter \x00 date id -> nil
+	bucketByDate    = []byte("by_date")    // date id -> nil
+	bucketByPath    = []byte("by_path")    // path -> id
+	bucketCounters  = []byte("counters")   // counter -> количество фото
+	bucketMeta      = []byte("meta")
+
+	metaLegacyImported = []byte("legacy_imported")
 )
 
-// Indexer управляет индексом фото по номерам счетчиков
+// indexOpenTimeout - сколько ждать, если база открыта другим процессом (например, запущенным сервером)
+const indexOpenTimeout = 2 * time.Second
+
+// Indexer управляет индексом фото по номерам счетчиков.
+// Индекс хранится во встроенной базе bbolt: каждое добавление - отдельная транзакция,
+// поэтому его стоимость не зависит от размера индекса, а сбой не повреждает базу.
 type Indexer struct {
 	indexDir string
-	index    map[string][]*PhotoInfo
-	mu       sync.RWMutex
+	db       *bolt.DB
 }
 
 // PhotoInfo содержит информацию о фото
@@ -25,167 +55,555 @@ type PhotoInfo struct {
 	Size        int64     `json:"size"`
 	Hash        string    `json:"hash"`
 	UserComment string    `json:"userComment,omitempty"` // USER_COMMENT из EXIF метаданных
+
+	// Откуда взяты дата съемки и номер счетчика (Source*)
+	DateSource    string `json:"dateSource,omitempty"`
+	CounterSource string `json:"counterSource,omitempty"`
+
+	// Данные из структурированного USER_COMMENT (см. metadata.Comment).
+	// Показание может быть передано и полем reading запроса, тогда ReadingSource = form.
+	// Reading - показание без регистра, Registers - показания именованных регистров.
+	CommentVersion int                `json:"commentVersion,omitempty"`
+	Reading        *float64           `json:"reading,omitempty"`
+	Registers      map[string]float64 `json:"registers,omitempty"`
+	ReadingSource  string             `json:"readingSource,omitempty"`
+	Apartment      string             `json:"apartment,omitempty"`
+	InspectorID    string             `json:"inspectorId,omitempty"`
+	Notes          string             `json:"notes,omitempty"`
+
+	// Подозрительное показание, отмеченное при приеме (см. DetectAnomalies)
+	Anomalies []models.Anomaly `json:"anomalies,omitempty"`
+
+	// Метаданные из EXIF
+	CameraMake  string                `json:"cameraMake,omitempty"`
+	CameraModel string                `json:"cameraModel,omitempty"`
+	Orientation int                   `json:"orientation,omitempty"`
+	Width       int                   `json:"width,omitempty"`
+	Height      int                   `json:"height,omitempty"`
+	GPS         *metadata.GPSPosition `json:"gps,omitempty"`
+}
+
+// RegisterReading возвращает показание регистра; показание без регистра - это metadata.DefaultRegister
+func (p *PhotoInfo) RegisterReading(register string) (float64, bool) {
+	if register == metadata.DefaultRegister {
+		if p.Reading == nil {
+			return 0, false
+		}
+		return *p.Reading, true
+	}
+	value, ok := p.Registers[register]
+	return value, ok
 }
 
-// NewIndexer создает новый индексер
-func NewIndexer(indexDir string) *Indexer {
-	indexer := &Indexer{
-		indexDir: indexDir,
-		index:    make(map[string][]*PhotoInfo),
+// SetReadings записывает показания по регистрам в Reading и Registers
+func (p *PhotoInfo) SetReadings(readings map[string]float64) {
+	p.Reading, p.Registers = nil, nil
+	for register, value := range readings {
+		if register == metadata.DefaultRegister {
+			value := value
+			p.Reading = &value
+			continue
+		}
+		if p.Registers == nil {
+			p.Registers = make(map[string]float64)
+		}
+		p.Registers[register] = value
 	}
+}
 
-	// Загружаем существующий индекс
-	indexer.loadIndex()
+// Источники значений PhotoInfo
+const (
+	SourceForm     = "form"     // передано устройством вместе с файлом
+	SourceEXIF     = "exif"     // прочитано из EXIF файла
+	SourceReceived = "received" // дата приема файла сервером
+	SourceUnknown  = "unknown"  // значение не найдено
+)
 
-	return indexer
+// photoRecord - запись фото в базе
+type photoRecord struct {
+	Counter string `json:"counter"`
+	PhotoInfo
+}
+
+// NewIndexer открывает базу индекса в indexDir.
+// Если рядом лежит JSON индекс прежней версии, он один раз импортируется в базу.
+func NewIndexer(indexDir string) (*Indexer, error) {
+	path := filepath.Join(indexDir, IndexFile)
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: indexOpenTimeout})
+	if err != nil {
+		if errors.Is(err, bolt.ErrTimeout) {
+			return nil, fmt.Errorf("index %s is used by another process (is the server running?)", path)
+		}
+		return nil, fmt.Errorf("failed to open index: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketPhotos, bucketByHash, bucketByCounter, bucketByDate, bucketByPath, bucketCounters, bucketMeta} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize index: %w", err)
+	}
+
+	indexer := &Indexer{indexDir: indexDir, db: db}
+	if err := indexer.importLegacyIndex(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return indexer, nil
+}
+
+// Close закрывает базу индекса
+func (idx *Indexer) Close() error {
+	return idx.db.Close()
 }
 
 // AddPhoto добавляет фото в индекс
-func (idx *Indexer) AddPhoto(counterNumber string, relPath string, fullPath string, date time.Time, size int64, hash string, userComment string) error {
-	idx.mu.Lock()
-	defer idx.mu.Unlock()
+func (idx *Indexer) AddPhoto(counterNumber string, photo *PhotoInfo) error {
+	record := &photoRecord{
+		Counter:   NormalizeCounterNumber(counterNumber),
+		PhotoInfo: *photo,
+	}
 
-	normalizedCounter := NormalizeCounterNumber(counterNumber)
-	
-	if idx.index[normalizedCounter] == nil {
-		idx.index[normalizedCounter] = []*PhotoInfo{}
+	err := idx.db.Update(func(tx *bolt.Tx) error {
+		return insertPhoto(tx, record)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save index: %w", err)
 	}
+	return nil
+}
 
-	// Проверяем, нет ли уже этого файла в индексе
-	for _, photo := range idx.index[normalizedCounter] {
-		if photo.Path == relPath {
-			return nil // Уже есть
+// GetPhotosByCounter возвращает все фото для указанного номера счетчика (новые первыми)
+func (idx *Indexer) GetPhotosByCounter(counterNumber string) []*PhotoInfo {
+	prefix := append([]byte(NormalizeCounterNumber(counterNumber)), 0)
+
+	var photos []*PhotoInfo
+	idx.db.View(func(tx *bolt.Tx) error {
+		photosBucket := tx.Bucket(bucketPhotos)
+		cursor := tx.Bucket(bucketByCounter).Cursor()
+
+		// Идем от конца префикса назад: ключи отсортированы по дате
+		end := append(append([]byte(nil), prefix[:len(prefix)-1]...), 1)
+		k, _ := cursor.Seek(end)
+		if k == nil {
+			k, _ = cursor.Last()
+		} else {
+			k, _ = cursor.Prev()
+		}
+		for ; k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Prev() {
+			if record := loadPhoto(photosBucket, k[len(k)-8:]); record != nil {
+				photos = append(photos, &record.PhotoInfo)
+			}
 		}
+		return nil
+	})
+	return photos
+}
+
+// FindByHash ищет фото с указанным хешем среди всех счетчиков
+func (idx *Indexer) FindByHash(hash string) *PhotoInfo {
+	if hash == "" {
+		return nil
 	}
+	prefix := append([]byte(hash), 0)
+
+	var photo *PhotoInfo
+	idx.db.View(func(tx *bolt.Tx) error {
+		k, _ := tx.Bucket(bucketByHash).Cursor().Seek(prefix)
+		if k != nil && bytes.HasPrefix(k, prefix) {
+			if record := loadPhoto(tx.Bucket(bucketPhotos), k[len(prefix):]); record != nil {
+				photo = &record.PhotoInfo
+			}
+		}
+		return nil
+	})
+	return photo
+}
+
+// FindByPath ищет фото по относительному пути
+func (idx *Indexer) FindByPath(relPath string) *PhotoInfo {
+	var photo *PhotoInfo
+	idx.db.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket(bucketByPath).Get(pathKey(relPath))
+		if id != nil {
+			if record := loadPhoto(tx.Bucket(bucketPhotos), id); record != nil {
+				photo = &record.PhotoInfo
+			}
+		}
+		return nil
+	})
+	return photo
+}
 
-	// Добавляем информацию о фото
-	photo := &PhotoInfo{
-		Path:        relPath,
-		FullPath:    fullPath,
-		Date:        date,
-		Size:        size,
-		Hash:        hash,
-		UserComment: userComment,
+// ForEachPhoto вызывает fn для каждого фото в индексе.
+// fn вызывается вне транзакции, поэтому может обращаться к индексу.
+func (idx *Indexer) ForEachPhoto(fn func(counterNumber string, photo *PhotoInfo)) {
+	var records []*photoRecord
+	idx.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketPhotos).ForEach(func(k, v []byte) error {
+			var record photoRecord
+			if err := json.Unmarshal(v, &record); err == nil {
+				records = append(records, &record)
+			}
+			return nil
+		})
+	})
+
+	for _, record := range records {
+		fn(record.Counter, &record.PhotoInfo)
 	}
+}
 
-	idx.index[normalizedCounter] = append(idx.index[normalizedCounter], photo)
+// ForEachPhotoInRange вызывает fn для фото с датой в [from, to] по возрастанию даты.
+// Нулевая граница означает отсутствие ограничения с этой стороны.
+func (idx *Indexer) ForEachPhotoInRange(from, to time.Time, fn func(counterNumber string, photo *PhotoInfo)) {
+	var records []*photoRecord
+	idx.db.View(func(tx *bolt.Tx) error {
+		photosBucket := tx.Bucket(bucketPhotos)
+		cursor := tx.Bucket(bucketByDate).Cursor()
 
-	// Сортируем по дате (новые первыми)
-	photos := idx.index[normalizedCounter]
-	for i := 0; i < len(photos)-1; i++ {
-		for j := i + 1; j < len(photos); j++ {
-			if photos[i].Date.Before(photos[j].Date) {
-				photos[i], photos[j] = photos[j], photos[i]
+		var k []byte
+		if from.IsZero() {
+			k, _ = cursor.First()
+		} else {
+			k, _ = cursor.Seek(dateKey(from))
+		}
+		var end []byte
+		if !to.IsZero() {
+			end = dateKey(to)
+		}
+		for ; k != nil; k, _ = cursor.Next() {
+			if end != nil && bytes.Compare(k[:8], end) > 0 {
+				break
 			}
+			record := loadPhoto(photosBucket, k[8:])
+			if record == nil {
+				continue
+			}
+			// Ключ хранит дату с точностью до секунды, границы сверяем точно
+			if (!from.IsZero() && record.Date.Before(from)) || (!to.IsZero() && record.Date.After(to)) {
+				continue
+			}
+			records = append(records, record)
 		}
+		return nil
+	})
+
+	for _, record := range records {
+		fn(record.Counter, &record.PhotoInfo)
 	}
+}
 
-	// Сохраняем индекс
-	return idx.saveIndex()
+// PathUpdate описывает перенос файла фото на новое место
+type PathUpdate struct {
+	OldPath     string
+	NewPath     string
+	NewFullPath string
 }
 
-// GetPhotosByCounter возвращает все фото для указанного номера счетчика
-func (idx *Indexer) GetPhotosByCounter(counterNumber string) []*PhotoInfo {
-	idx.mu.RLock()
-	defer idx.mu.RUnlock()
+// UpdatePaths меняет пути фото в индексе одной транзакцией для всего пакета
+func (idx *Indexer) UpdatePaths(updates []PathUpdate) error {
+	if len(updates) == 0 {
+		return nil
+	}
+
+	err := idx.db.Update(func(tx *bolt.Tx) error {
+		photosBucket := tx.Bucket(bucketPhotos)
+		byPath := tx.Bucket(bucketByPath)
 
-	normalizedCounter := NormalizeCounterNumber(counterNumber)
-	return idx.index[normalizedCounter]
+		for _, update := range updates {
+			id := byPath.Get(pathKey(update.OldPath))
+			if id == nil {
+				continue
+			}
+			id = append([]byte(nil), id...)
+
+			record := loadPhoto(photosBucket, id)
+			if record == nil {
+				continue
+			}
+			record.Path = update.NewPath
+			record.FullPath = update.NewFullPath
+
+			if err := byPath.Delete(pathKey(update.OldPath)); err != nil {
+				return err
+			}
+			if err := byPath.Put(pathKey(update.NewPath), id); err != nil {
+				return err
+			}
+			if err := putPhoto(photosBucket, id, record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save index: %w", err)
+	}
+	return nil
 }
 
 // GetAllCounters возвращает все номера счетчиков в индексе
 func (idx *Indexer) GetAllCounters() []string {
-	idx.mu.RLock()
-	defer idx.mu.RUnlock()
+	var counters []string
+	idx.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketCounters).ForEach(func(k, v []byte) error {
+			counters = append(counters, string(k))
+			return nil
+		})
+	})
+	return counters
+}
 
-	counters := make([]string, 0, len(idx.index))
-	for counter := range idx.index {
-		counters = append(counters, counter)
+// CounterStats - сводка по фото счетчика
+type CounterStats struct {
+	Photos    int
+	TotalSize int64
+	FirstDate time.Time  // дата съемки самого старого фото
+	LastDate  time.Time  // дата съемки самого нового фото
+	LastPhoto *PhotoInfo // самое новое фото
+}
+
+// add учитывает очередное фото счетчика; фото передаются по возрастанию даты
+func (s *CounterStats) add(photo *PhotoInfo) {
+	if s.Photos == 0 {
+		s.FirstDate = photo.Date
 	}
-	return counters
+	s.Photos++
+	s.TotalSize += photo.Size
+	s.LastDate = photo.Date
+	s.LastPhoto = photo
+}
+
+// GetCounterStats возвращает сводку по одному счетчику тем же способом, что и AllCounterStats
+func (idx *Indexer) GetCounterStats(counterNumber string) CounterStats {
+	prefix := append([]byte(NormalizeCounterNumber(counterNumber)), 0)
+
+	var stats CounterStats
+	idx.db.View(func(tx *bolt.Tx) error {
+		photosBucket := tx.Bucket(bucketPhotos)
+		cursor := tx.Bucket(bucketByCounter).Cursor()
+		for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+			if record := loadPhoto(photosBucket, k[len(k)-8:]); record != nil {
+				stats.add(&record.PhotoInfo)
+			}
+		}
+		return nil
+	})
+	return stats
 }
 
-// loadIndex загружает индекс из файла
-func (idx *Indexer) loadIndex() {
-	indexFile := filepath.Join(idx.indexDir, "photo_index.json")
-	
-	data, err := os.ReadFile(indexFile)
+// AllCounterStats возвращает сводку по всем счетчикам за один проход индекса by_counter.
+// Ключ - номер счетчика в виде NormalizeCounterNumber.
+func (idx *Indexer) AllCounterStats() map[string]CounterStats {
+	stats := make(map[string]CounterStats)
+	idx.db.View(func(tx *bolt.Tx) error {
+		photosBucket := tx.Bucket(bucketPhotos)
+		cursor := tx.Bucket(bucketByCounter).Cursor()
+		for k, _ := cursor.First(); k != nil; k, _ = cursor.Next() {
+			// Ключ: счетчик \x00 дата(8) id(8); ключи счетчика идут по возрастанию даты
+			if len(k) < 17 {
+				continue
+			}
+			record := loadPhoto(photosBucket, k[len(k)-8:])
+			if record == nil {
+				continue
+			}
+			counter := string(k[:len(k)-17])
+			s := stats[counter]
+			s.add(&record.PhotoInfo)
+			stats[counter] = s
+		}
+		return nil
+	})
+	return stats
+}
+
+// Count возвращает количество фото в индексе
+func (idx *Indexer) Count() int {
+	var count int
+	idx.db.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket(bucketPhotos).Stats().KeyN
+		return nil
+	})
+	return count
+}
+
+// insertPhoto добавляет запись и все вторичные индексы (вызывается в транзакции записи)
+func insertPhoto(tx *bolt.Tx, record *photoRecord) error {
+	byPath := tx.Bucket(bucketByPath)
+	if byPath.Get(pathKey(record.Path)) != nil {
+		return nil // Уже есть
+	}
+
+	photosBucket := tx.Bucket(bucketPhotos)
+	seq, err := photosBucket.NextSequence()
 	if err != nil {
-		if !os.IsNotExist(err) {
-			fmt.Printf("Warning: Failed to load index: %v\n", err)
+		return err
+	}
+	id := make([]byte, 8)
+	binary.BigEndian.PutUint64(id, seq)
+
+	if err := putPhoto(photosBucket, id, record); err != nil {
+		return err
+	}
+	if err := byPath.Put(pathKey(record.Path), id); err != nil {
+		return err
+	}
+	if record.Hash != "" {
+		if err := tx.Bucket(bucketByHash).Put(joinKey([]byte(record.Hash), []byte{0}, id), nil); err != nil {
+			return err
 		}
-		return
+	}
+	date := dateKey(record.Date)
+	if err := tx.Bucket(bucketByCounter).Put(joinKey([]byte(record.Counter), []byte{0}, date, id), nil); err != nil {
+		return err
+	}
+	if err := tx.Bucket(bucketByDate).Put(joinKey(date, id), nil); err != nil {
+		return err
 	}
 
-	var indexData map[string][]map[string]interface{}
-	if err := json.Unmarshal(data, &indexData); err != nil {
-		fmt.Printf("Warning: Failed to parse index: %v\n", err)
-		return
+	counters := tx.Bucket(bucketCounters)
+	count := uint64(0)
+	if v := counters.Get([]byte(record.Counter)); len(v) == 8 {
+		count = binary.BigEndian.Uint64(v)
 	}
+	countValue := make([]byte, 8)
+	binary.BigEndian.PutUint64(countValue, count+1)
+	return counters.Put([]byte(record.Counter), countValue)
+}
 
-	// Конвертируем даты из строк в time.Time
-	for counter, photosData := range indexData {
-		convertedPhotos := make([]*PhotoInfo, 0, len(photosData))
-		for _, photoData := range photosData {
-			photo := &PhotoInfo{
-				Path:        getString(photoData, "path"),
-				FullPath:    getString(photoData, "fullPath"),
-				Size:        getInt64(photoData, "size"),
-				Hash:        getString(photoData, "hash"),
-				UserComment: getString(photoData, "userComment"),
-			}
+// putPhoto сохраняет запись фото
+func putPhoto(bucket *bolt.Bucket, id []byte, record *photoRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(id, data)
+}
 
-			// Парсим дату
-			if dateStr, ok := photoData["date"].(string); ok {
-				if parsed, err := time.Parse(time.RFC3339, dateStr); err == nil {
-					photo.Date = parsed
-				} else {
-					photo.Date = time.Now()
-				}
-			} else {
-				photo.Date = time.Now()
-			}
+// loadPhoto читает запись фото по ID
+func loadPhoto(bucket *bolt.Bucket, id []byte) *photoRecord {
+	data := bucket.Get(id)
+	if data == nil {
+		return nil
+	}
+	var record photoRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil
+	}
+	return &record
+}
 
-			convertedPhotos = append(convertedPhotos, photo)
-		}
-		idx.index[counter] = convertedPhotos
+// dateKey кодирует дату так, чтобы байтовый порядок совпадал с хронологическим
+func dateKey(date time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(date.Unix())^(1<<63))
+	return key
+}
+
+// pathKey нормализует путь для индекса: пути из индекса и из запросов сравниваются после Clean
+func pathKey(relPath string) []byte {
+	return []byte(filepath.Clean(relPath))
+}
+
+// joinKey склеивает части составного ключа
+func joinKey(parts ...[]byte) []byte {
+	var key []byte
+	for _, part := range parts {
+		key = append(key, part...)
 	}
+	return key
 }
 
-// saveIndex сохраняет индекс в файл
-func (idx *Indexer) saveIndex() error {
-	indexFile := filepath.Join(idx.indexDir, "photo_index.json")
+// importLegacyIndex переносит photo_index.json прежних версий в базу.
+// Импорт выполняется одной транзакцией, после него JSON файл переименовывается в .imported.
+func (idx *Indexer) importLegacyIndex() error {
+	var imported bool
+	idx.db.View(func(tx *bolt.Tx) error {
+		imported = tx.Bucket(bucketMeta).Get(metaLegacyImported) != nil
+		return nil
+	})
+	if imported {
+		return nil
+	}
 
-	// Конвертируем индекс в JSON-совместимый формат
-	indexData := make(map[string][]map[string]interface{})
-	for counter, photos := range idx.index {
-		photoList := make([]map[string]interface{}, len(photos))
-		for i, photo := range photos {
-			photoMap := map[string]interface{}{
-				"path":     photo.Path,
-				"fullPath": photo.FullPath,
-				"date":     photo.Date.Format(time.RFC3339),
-				"size":     photo.Size,
-				"hash":     photo.Hash,
-			}
-			if photo.UserComment != "" {
-				photoMap["userComment"] = photo.UserComment
+	legacyPath := filepath.Join(idx.indexDir, LegacyIndexFile)
+	records, err := loadLegacyIndex(legacyPath)
+	if err != nil {
+		return err
+	}
+
+	err = idx.db.Update(func(tx *bolt.Tx) error {
+		for _, record := range records {
+			if err := insertPhoto(tx, record); err != nil {
+				return err
 			}
-			photoList[i] = photoMap
 		}
-		indexData[counter] = photoList
+		return tx.Bucket(bucketMeta).Put(metaLegacyImported, []byte(time.Now().Format(time.RFC3339)))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to import %s: %w", LegacyIndexFile, err)
+	}
+
+	if len(records) > 0 {
+		fmt.Printf("Imported %d photos from %s\n", len(records), legacyPath)
+		if err := os.Rename(legacyPath, legacyPath+".imported"); err != nil {
+			fmt.Printf("Warning: Failed to rename %s: %v\n", legacyPath, err)
+		}
 	}
+	return nil
+}
 
-	data, err := json.MarshalIndent(indexData, "", "  ")
+// loadLegacyIndex читает JSON индекс прежних версий (нет файла - пустой список)
+func loadLegacyIndex(path string) ([]*photoRecord, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return fmt.Errorf("failed to marshal index: %w", err)
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to load %s: %w", path, err)
 	}
 
-	if err := os.WriteFile(indexFile, data, 0644); err != nil {
-		return fmt.Errorf("failed to save index: %w", err)
+	var indexData map[string][]map[string]interface{}
+	if err := json.Unmarshal(data, &indexData); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
 	}
 
-	return nil
+	var records []*photoRecord
+	for counter, photosData := range indexData {
+		for _, photoData := range photosData {
+			record := &photoRecord{
+				Counter: counter,
+				PhotoInfo: PhotoInfo{
+					Path:        getString(photoData, "path"),
+					FullPath:    getString(photoData, "fullPath"),
+					Size:        getInt64(photoData, "size"),
+					Hash:        getString(photoData, "hash"),
+					UserComment: getString(photoData, "userComment"),
+				},
+			}
+
+			// Парсим дату
+			record.Date = time.Now()
+			if dateStr, ok := photoData["date"].(string); ok {
+				if parsed, err := time.Parse(time.RFC3339, dateStr); err == nil {
+					record.Date = parsed
+				}
+			}
+
+			if record.Path != "" {
+				records = append(records, record)
+			}
+		}
+	}
+	return records, nil
 }
 
 // NormalizeCounterNumber нормализует номер счетчика для сравнения
@@ -193,8 +611,8 @@ func NormalizeCounterNumber(counterNumber string) string {
 	// Приводим к нижнему регистру и удаляем спецсимволы
 	result := strings.ToLower(counterNumber)
 	result = strings.Map(func(r rune) rune {
-		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || 
-		   (r >= 'а' && r <= 'я') || r == 'ё' {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') ||
+			(r >= 'а' && r <= 'я') || r == 'ё' {
 			return r
 		}
 		return -1 // Удаляем символ
@@ -214,7 +632,7 @@ func getString(m map[string]interface{}, key string) string {
 func getInt64(m map[string]interface{}, key string) int64 {
 	switch v := m[key].(type) {
 	case int64:
-		return v
+		return int64(v)
 	case int:
 		return int64(v)
 	case float64:
@@ -223,4 +641,3 @@ func getInt64(m map[string]interface{}, key string) int64 {
 		return 0
 	}
 }
-
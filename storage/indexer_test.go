@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func newTestIndexer(tb testing.TB) *Indexer {
+	tb.Helper()
+	indexer, err := NewIndexer(tb.TempDir())
+	if err != nil {
+		tb.Fatalf("NewIndexer: %v", err)
+	}
+	tb.Cleanup(func() { indexer.Close() })
+	return indexer
+}
+
+func TestForEachPhotoInRange(t *testing.T) {
+	indexer := newTestIndexer(t)
+	base := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		photo := &PhotoInfo{
+			Path: fmt.Sprintf("0011067/2025-03/%d.jpg", i),
+			Date: base.AddDate(0, 0, i).Add(500 * time.Millisecond),
+		}
+		if err := indexer.AddPhoto("0011067", photo); err != nil {
+			t.Fatalf("AddPhoto: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		from, to time.Time
+		want     []string
+	}{
+		{"all", time.Time{}, time.Time{}, []string{"0", "1", "2", "3", "4"}},
+		{"from", base.AddDate(0, 0, 3), time.Time{}, []string{"3", "4"}},
+		{"to", time.Time{}, base.AddDate(0, 0, 1).Add(500 * time.Millisecond), []string{"0", "1"}},
+		{"range", base.AddDate(0, 0, 1), base.AddDate(0, 0, 3), []string{"1", "2"}},
+		// Ключ by_date хранит секунды, граница внутри секунды сверяется по дате фото
+		{"sub-second", base.AddDate(0, 0, 2).Add(600 * time.Millisecond), time.Time{}, []string{"3", "4"}},
+		{"empty", base.AddDate(0, 1, 0), time.Time{}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			indexer.ForEachPhotoInRange(tt.from, tt.to, func(counterNumber string, photo *PhotoInfo) {
+				if counterNumber != "0011067" {
+					t.Errorf("counter = %q", counterNumber)
+				}
+				got = append(got, photo.Path[len("0011067/2025-03/"):len(photo.Path)-len(".jpg")])
+			})
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllCounterStats(t *testing.T) {
+	indexer := newTestIndexer(t)
+	base := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	// Фото добавляются не по порядку дат, сводка все равно считается по дате съемки
+	photos := []struct {
+		counter string
+		days    int
+		size    int64
+	}{
+		{"0011067", 2, 100},
+		{"0011067", 0, 10},
+		{"00-11 067", 5, 1000},
+		{"0022000", 1, 7},
+	}
+	for i, p := range photos {
+		photo := &PhotoInfo{
+			Path: fmt.Sprintf("%s/%d.jpg", p.counter, i),
+			Date: base.AddDate(0, 0, p.days),
+			Size: p.size,
+		}
+		if err := indexer.AddPhoto(p.counter, photo); err != nil {
+			t.Fatalf("AddPhoto: %v", err)
+		}
+	}
+
+	stats := indexer.AllCounterStats()
+	if len(stats) != 2 {
+		t.Fatalf("got %d counters, want 2", len(stats))
+	}
+	got := stats["0011067"]
+	if got.Photos != 3 || got.TotalSize != 1110 {
+		t.Errorf("photos = %d, size = %d, want 3 and 1110", got.Photos, got.TotalSize)
+	}
+	if !got.FirstDate.Equal(base) || !got.LastDate.Equal(base.AddDate(0, 0, 5)) {
+		t.Errorf("dates = %v .. %v", got.FirstDate, got.LastDate)
+	}
+	if got.LastPhoto == nil || got.LastPhoto.Path != "00-11 067/2.jpg" {
+		t.Errorf("last photo = %+v", got.LastPhoto)
+	}
+	if other := stats["0022000"]; other.Photos != 1 || other.TotalSize != 7 {
+		t.Errorf("0022000 = %+v", other)
+	}
+
+	// Сводка одного счетчика совпадает со сводкой из общего прохода
+	for _, counter := range []string{"00-11-067", "0022000", "0099999"} {
+		one := indexer.GetCounterStats(counter)
+		all := stats[NormalizeCounterNumber(counter)]
+		if one.Photos != all.Photos || one.TotalSize != all.TotalSize || !one.LastDate.Equal(all.LastDate) {
+			t.Errorf("GetCounterStats(%q) = %+v, want %+v", counter, one, all)
+		}
+	}
+}
+
+func BenchmarkAddPhoto(b *testing.B) {
+	indexer := newTestIndexer(b)
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	reading := 123.4
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		counter := fmt.Sprintf("%07d", i%100)
+		photo := &PhotoInfo{
+			Path:    fmt.Sprintf("%s/2025-01/photo_%d.jpg", counter, i),
+			Date:    base.Add(time.Duration(i) * time.Minute),
+			Size:    2 << 20,
+			Hash:    fmt.Sprintf("%064x", i),
+			Reading: &reading,
+		}
+		if err := indexer.AddPhoto(counter, photo); err != nil {
+			b.Fatalf("AddPhoto: %v", err)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// DefaultLayout - шаблон раскладки фото по папкам по умолчанию
+const DefaultLayout = "{counter}/{yyyy}/{mm}/{counter}_{yyyyMMdd_HHmmss}{ext}"
+
+// LegacyLayout повторяет старую плоскую раскладку (все файлы в корне папки)
+const LegacyLayout = "{name}{ext}"
+
+// layoutTokens - поддерживаемые подстановки шаблона
+var layoutTokens = map[string]func(v LayoutVars) string{
+	"counter":         func(v LayoutVars) string { return v.counterDir() },
+	"yyyy":            func(v LayoutVars) string { return v.Date.Format("2006") },
+	"mm":              func(v LayoutVars) string { return v.Date.Format("01") },
+	"dd":              func(v LayoutVars) string { return v.Date.Format("02") },
+	"yyyyMMdd":        func(v LayoutVars) string { return v.Date.Format("20060102") },
+	"HHmmss":          func(v LayoutVars) string { return v.Date.Format("150405") },
+	"yyyyMMdd_HHmmss": func(v LayoutVars) string { return v.Date.Format("20060102_150405") },
+	"name":            func(v LayoutVars) string { return sanitizePathComponent(v.Name, "photo") },
+	"hash":            func(v LayoutVars) string { return shortHash(v.Hash) },
+	"ext":             func(v LayoutVars) string { return v.extension() },
+}
+
+// Layout - разобранный шаблон пути, например {counter}/{yyyy}/{mm}/{counter}_{yyyyMMdd_HHmmss}{ext}
+type Layout struct {
+	template string
+}
+
+// LayoutVars - значения для подстановки в шаблон
+type LayoutVars struct {
+	Counter string    // номер счетчика (нормализуется)
+	Date    time.Time // дата съемки
+	Name    string    // исходное имя файла без расширения
+	Ext     string    // расширение с точкой
+	Hash    string    // SHA256 хеш файла
+}
+
+// ParseLayout проверяет шаблон раскладки и возвращает его
+func ParseLayout(template string) (*Layout, error) {
+	template = strings.TrimSpace(template)
+	if template == "" {
+		return nil, fmt.Errorf("layout template is empty")
+	}
+	if strings.Contains(template, "\\") {
+		return nil, fmt.Errorf("layout template %q must use / as path separator", template)
+	}
+	if strings.HasPrefix(template, "/") {
+		return nil, fmt.Errorf("layout template %q must be relative", template)
+	}
+
+	rest := template
+	for {
+		start := strings.Index(rest, "{")
+		if start < 0 {
+			if strings.Contains(rest, "}") {
+				return nil, fmt.Errorf("layout template %q has unbalanced braces", template)
+			}
+			break
+		}
+		end := strings.Index(rest[start:], "}")
+		if end < 0 {
+			return nil, fmt.Errorf("layout template %q has unbalanced braces", template)
+		}
+		token := rest[start+1 : start+end]
+		if _, ok := layoutTokens[token]; !ok {
+			return nil, fmt.Errorf("layout template %q has unknown placeholder {%s}", template, token)
+		}
+		rest = rest[start+end+1:]
+	}
+
+	for _, segment := range strings.Split(template, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return nil, fmt.Errorf("layout template %q has invalid path segment %q", template, segment)
+		}
+	}
+
+	return &Layout{template: template}, nil
+}
+
+// String возвращает исходный шаблон
+func (l *Layout) String() string {
+	return l.template
+}
+
+// Render подставляет значения в шаблон и возвращает относительный путь с разделителем /
+func (l *Layout) Render(vars LayoutVars) string {
+	var b strings.Builder
+	rest := l.template
+	for {
+		start := strings.Index(rest, "{")
+		if start < 0 {
+			b.WriteString(rest)
+			break
+		}
+		end := strings.Index(rest[start:], "}")
+		b.WriteString(rest[:start])
+		b.WriteString(layoutTokens[rest[start+1:start+end]](vars))
+		rest = rest[start+end+1:]
+	}
+	return path.Clean(b.String())
+}
+
+// counterDir возвращает нормализованный номер счетчика, пригодный для имени папки
+func (v LayoutVars) counterDir() string {
+	counter := NormalizeCounterNumber(v.Counter)
+	if counter == "" {
+		return "unknown"
+	}
+	return counter
+}
+
+// extension возвращает расширение в нижнем регистре, по умолчанию .jpg
+func (v LayoutVars) extension() string {
+	ext := strings.ToLower(sanitizePathComponent(v.Ext, ""))
+	if ext == "" || ext == "." {
+		return ".jpg"
+	}
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return ext
+}
+
+// withSuffix добавляет к пути суффикс _N перед расширением для разрешения конфликтов имен
+func withSuffix(relPath string, n int) string {
+	ext := path.Ext(relPath)
+	return fmt.Sprintf("%s_%d%s", relPath[:len(relPath)-len(ext)], n, ext)
+}
+
+// sanitizePathComponent убирает из строки символы, недопустимые в имени файла Windows
+func sanitizePathComponent(s string, fallback string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r < 0x20:
+			return -1
+		case strings.ContainsRune(`<>:"/\|?*`, r):
+			return '_'
+		}
+		return r
+	}, s)
+	s = strings.Trim(s, " .")
+	if s == "" {
+		return fallback
+	}
+	return s
+}
+
+// shortHash возвращает первые 12 символов хеша
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	if hash == "" {
+		return "nohash"
+	}
+	return hash
+}
@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"photo-sync-server/models"
+)
+
+// MeterCSVError описывает строку CSV, которую не удалось импортировать
+type MeterCSVError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// meterCSVColumns сопоставляет заголовки CSV (без регистра, пробелов, "_" и "-") с полями счетчика
+var meterCSVColumns = map[string]string{
+	"serialnumber":   "serial",
+	"serial":         "serial",
+	"number":         "serial",
+	"counter":        "serial",
+	"counternumber":  "serial",
+	"номер":          "serial",
+	"номерсчетчика":  "serial",
+	"заводскойномер": "serial",
+	"type":           "type",
+	"тип":            "type",
+	"address":        "address",
+	"адрес":          "address",
+	"account":        "account",
+	"лицевойсчет":    "account",
+	"installdate":    "installDate",
+	"датаустановки":  "installDate",
+}
+
+// meterTypeNames - русские названия видов учета, которые встречаются в выгрузках
+var meterTypeNames = map[string]models.MeterType{
+	"вода":           models.MeterWater,
+	"гвс":            models.MeterHotWater,
+	"горячаявода":    models.MeterHotWater,
+	"хвс":            models.MeterColdWater,
+	"холоднаявода":   models.MeterColdWater,
+	"газ":            models.MeterGas,
+	"электроэнергия": models.MeterElectric,
+	"электричество":  models.MeterElectric,
+	"тепло":          models.MeterHeat,
+	"теплоэнергия":   models.MeterHeat,
+	"отопление":      models.MeterHeat,
+	"hotwater":       models.MeterHotWater,
+	"coldwater":      models.MeterColdWater,
+	"electricity":    models.MeterElectric,
+}
+
+// ParseMetersCSV читает реестр счетчиков из CSV с заголовком.
+// Разделитель (";", "," или табуляция) определяется по заголовку. Файл может быть
+// в UTF-8 (с BOM или без) или в Windows-1251, как его сохраняет русский Excel.
+// Строки с ошибками возвращаются отдельно и не прерывают разбор остальных.
+func ParseMetersCSV(r io.Reader) ([]*models.Meter, []MeterCSVError, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read CSV: %w", err)
+	}
+	data = bytes.TrimPrefix(data, []byte("\xEF\xBB\xBF"))
+	if !utf8.Valid(data) {
+		data = decodeWindows1251(data)
+	}
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma = detectCSVDelimiter(data)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		key := strings.NewReplacer(" ", "", "_", "", "-", "").Replace(strings.ToLower(strings.TrimSpace(name)))
+		if field, ok := meterCSVColumns[key]; ok {
+			columns[field] = i
+		}
+	}
+	if _, ok := columns["serial"]; !ok {
+		return nil, nil, fmt.Errorf("CSV header must contain a serial_number column")
+	}
+	if _, ok := columns["type"]; !ok {
+		return nil, nil, fmt.Errorf("CSV header must contain a type column")
+	}
+
+	var meters []*models.Meter
+	var rowErrors []MeterCSVError
+	seen := make(map[string]int) // нормализованный номер -> строка, где он встретился впервые
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		if err != nil {
+			rowErrors = append(rowErrors, MeterCSVError{Line: line, Error: err.Error()})
+			continue
+		}
+
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue // пустая строка
+		}
+		value := func(field string) string {
+			if i, ok := columns[field]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		meter := &models.Meter{
+			SerialNumber: value("serial"),
+			Type:         parseMeterType(value("type")),
+			Address:      value("address"),
+			Account:      value("account"),
+			InstallDate:  value("installDate"),
+		}
+		if err := validateMeter(meter); err != nil {
+			rowErrors = append(rowErrors, MeterCSVError{Line: line, Error: err.Error()})
+			continue
+		}
+		// Один счетчик в разном написании ("00-11 067" и "0011067") иначе молча
+		// перезапишет сам себя при импорте; остается первая строка
+		key := NormalizeCounterNumber(meter.SerialNumber)
+		if first, ok := seen[key]; ok {
+			rowErrors = append(rowErrors, MeterCSVError{
+				Line:  line,
+				Error: fmt.Sprintf("duplicate serial number %q: same meter as line %d", meter.SerialNumber, first),
+			})
+			continue
+		}
+		seen[key] = line
+		meters = append(meters, meter)
+	}
+	return meters, rowErrors, nil
+}
+
+// parseMeterType принимает вид учета как в API или по-русски ("ХВС", "Газ")
+func parseMeterType(value string) models.MeterType {
+	key := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(value), " ", ""))
+	if meterType, ok := meterTypeNames[key]; ok {
+		return meterType
+	}
+	return models.MeterType(key)
+}
+
+// detectCSVDelimiter выбирает самый частый разделитель в первой строке
+func detectCSVDelimiter(data []byte) rune {
+	firstLine := data
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		firstLine = data[:i]
+	}
+	delimiter, best := ',', bytes.Count(firstLine, []byte(","))
+	for _, candidate := range []rune{';', '\t'} {
+		if count := bytes.Count(firstLine, []byte(string(candidate))); count > best {
+			delimiter, best = candidate, count
+		}
+	}
+	return delimiter
+}
+
+// windows1251High - символы Windows-1251 с кодами 0x80-0xBF (0xC0-0xFF - А-я подряд)
+var windows1251High = [64]rune{
+	'Ђ', 'Ѓ', '‚', 'ѓ', '„', '…', '†', '‡', '€', '‰', 'Љ', '‹', 'Њ', 'Ќ', 'Ћ', 'Џ',
+	'ђ', '‘', '’', '“', '”', '•', '–', '—', utf8.RuneError, '™', 'љ', '›', 'њ', 'ќ', 'ћ', 'џ',
+	'\u00A0', 'Ў', 'ў', 'Ј', '¤', 'Ґ', '¦', '§', 'Ё', '©', 'Є', '«', '¬', '\u00AD', '®', 'Ї',
+	'°', '±', 'І', 'і', 'ґ', 'µ', '¶', '·', 'ё', '№', 'є', '»', 'ј', 'Ѕ', 'ѕ', 'ї',
+}
+
+// decodeWindows1251 перекодирует текст из Windows-1251 в UTF-8
+func decodeWindows1251(data []byte) []byte {
+	var result bytes.Buffer
+	result.Grow(len(data) * 2)
+	for _, b := range data {
+		switch {
+		case b < 0x80:
+			result.WriteByte(b)
+		case b < 0xC0:
+			result.WriteRune(windows1251High[b-0x80])
+		default:
+			result.WriteRune('А' + rune(b-0xC0))
+		}
+	}
+	return result.Bytes()
+}
@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMetersCSVDuplicates(t *testing.T) {
+	data := "Номер;Тип;Адрес\n" +
+		"00-11 067;ХВС;ул. Мира, 5\n" +
+		"0022000;Газ;ул. Мира, 7\n" +
+		"0011067;ГВС;ул. Мира, 9\n" +
+		"0022000;Газ;ул. Мира, 7\n"
+
+	meters, rowErrors, err := ParseMetersCSV(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(meters) != 2 || meters[0].SerialNumber != "00-11 067" || meters[0].Address != "ул. Мира, 5" || meters[1].SerialNumber != "0022000" {
+		t.Fatalf("meters = %+v", meters)
+	}
+	if len(rowErrors) != 2 {
+		t.Fatalf("row errors = %+v, want 2", rowErrors)
+	}
+	for i, want := range []struct {
+		line  int
+		first string
+	}{{4, "line 2"}, {5, "line 3"}} {
+		if rowErrors[i].Line != want.line || !strings.Contains(rowErrors[i].Error, want.first) {
+			t.Errorf("row error %d = %+v, want line %d mentioning %s", i, rowErrors[i], want.line, want.first)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"photo-sync-server/models"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// MeterRegistryFile - файл базы реестра счетчиков в папке индекса
+const MeterRegistryFile = "meters.db"
+
+var bucketMeters = []byte("meters") // нормализованный номер -> models.Meter (JSON)
+
+var (
+	// ErrMeterNotFound возвращается, если счетчика нет в реестре
+	ErrMeterNotFound = errors.New("meter not found")
+	// ErrMeterExists возвращается при добавлении счетчика, который уже есть в реестре
+	ErrMeterExists = errors.New("meter already exists")
+	// ErrInvalidMeter возвращается, если поля счетчика не прошли проверку
+	ErrInvalidMeter = errors.New("invalid meter")
+)
+
+// MeterRegistry - реестр счетчиков. Счетчики ищутся по нормализованному номеру
+// (NormalizeCounterNumber), поэтому "00-11 067" и "0011067" - один счетчик.
+type MeterRegistry struct {
+	db *bolt.DB
+}
+
+// NewMeterRegistry открывает реестр счетчиков в indexDir
+func NewMeterRegistry(indexDir string) (*MeterRegistry, error) {
+	path := filepath.Join(indexDir, MeterRegistryFile)
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: indexOpenTimeout})
+	if err != nil {
+		if errors.Is(err, bolt.ErrTimeout) {
+			return nil, fmt.Errorf("meter registry %s is used by another process (is the server running?)", path)
+		}
+		return nil, fmt.Errorf("failed to open meter registry: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketMeters)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize meter registry: %w", err)
+	}
+	return &MeterRegistry{db: db}, nil
+}
+
+// Close закрывает базу реестра
+func (r *MeterRegistry) Close() error {
+	return r.db.Close()
+}
+
+// Count возвращает количество счетчиков в реестре
+func (r *MeterRegistry) Count() int {
+	var count int
+	r.db.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket(bucketMeters).Stats().KeyN
+		return nil
+	})
+	return count
+}
+
+// List возвращает все счетчики, отсортированные по номеру
+func (r *MeterRegistry) List() []*models.Meter {
+	var meters []*models.Meter
+	r.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketMeters).ForEach(func(k, v []byte) error {
+			var meter models.Meter
+			if err := json.Unmarshal(v, &meter); err == nil {
+				meters = append(meters, &meter)
+			}
+			return nil
+		})
+	})
+
+	sort.Slice(meters, func(i, j int) bool {
+		return meters[i].SerialNumber < meters[j].SerialNumber
+	})
+	return meters
+}
+
+// Get ищет счетчик по номеру в любом написании
+func (r *MeterRegistry) Get(counterNumber string) (*models.Meter, bool) {
+	key := meterKey(counterNumber)
+	if key == nil {
+		return nil, false
+	}
+
+	var meter *models.Meter
+	r.db.View(func(tx *bolt.Tx) error {
+		meter = getMeter(tx, key)
+		return nil
+	})
+	return meter, meter != nil
+}
+
+// Create добавляет счетчик в реестр
+func (r *MeterRegistry) Create(meter *models.Meter) error {
+	if err := validateMeter(meter); err != nil {
+		return err
+	}
+	key := meterKey(meter.SerialNumber)
+
+	return r.db.Update(func(tx *bolt.Tx) error {
+		if getMeter(tx, key) != nil {
+			return ErrMeterExists
+		}
+		meter.CreatedAt = time.Now()
+		meter.UpdatedAt = meter.CreatedAt
+		return putMeter(tx, key, meter)
+	})
+}
+
+// Update заменяет поля счетчика counterNumber. Номер счетчика можно исправить,
+// если новый номер не занят другим счетчиком.
+func (r *MeterRegistry) Update(counterNumber string, meter *models.Meter) error {
+	if err := validateMeter(meter); err != nil {
+		return err
+	}
+	oldKey := meterKey(counterNumber)
+	newKey := meterKey(meter.SerialNumber)
+
+	return r.db.Update(func(tx *bolt.Tx) error {
+		existing := getMeter(tx, oldKey)
+		if existing == nil {
+			return ErrMeterNotFound
+		}
+		if string(newKey) != string(oldKey) {
+			if getMeter(tx, newKey) != nil {
+				return ErrMeterExists
+			}
+			if err := tx.Bucket(bucketMeters).Delete(oldKey); err != nil {
+				return err
+			}
+		}
+		meter.CreatedAt = existing.CreatedAt
+		meter.UpdatedAt = time.Now()
+		return putMeter(tx, newKey, meter)
+	})
+}
+
+// Delete удаляет счетчик из реестра. Фото счетчика остаются в индексе.
+func (r *MeterRegistry) Delete(counterNumber string) error {
+	key := meterKey(counterNumber)
+	return r.db.Update(func(tx *bolt.Tx) error {
+		if getMeter(tx, key) == nil {
+			return ErrMeterNotFound
+		}
+		return tx.Bucket(bucketMeters).Delete(key)
+	})
+}
+
+// Import добавляет или обновляет счетчики одной транзакцией
+func (r *MeterRegistry) Import(meters []*models.Meter) (created, updated int, err error) {
+	err = r.db.Update(func(tx *bolt.Tx) error {
+		created, updated = 0, 0
+		now := time.Now()
+		for _, meter := range meters {
+			if err := validateMeter(meter); err != nil {
+				return fmt.Errorf("meter %s: %w", meter.SerialNumber, err)
+			}
+			key := meterKey(meter.SerialNumber)
+
+			meter.CreatedAt, meter.UpdatedAt = now, now
+			if existing := getMeter(tx, key); existing != nil {
+				meter.CreatedAt = existing.CreatedAt
+				updated++
+			} else {
+				created++
+			}
+			if err := putMeter(tx, key, meter); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return created, updated, err
+}
+
+// validateMeter проверяет поля счетчика и то, что номер не пустой после нормализации
+func validateMeter(meter *models.Meter) error {
+	if err := meter.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidMeter, err)
+	}
+	if meterKey(meter.SerialNumber) == nil {
+		return fmt.Errorf("%w: serialNumber must contain letters or digits", ErrInvalidMeter)
+	}
+	return nil
+}
+
+// meterKey - ключ счетчика в реестре (nil, если номер пустой после нормализации)
+func meterKey(counterNumber string) []byte {
+	normalized := NormalizeCounterNumber(counterNumber)
+	if normalized == "" {
+		return nil
+	}
+	return []byte(normalized)
+}
+
+func getMeter(tx *bolt.Tx, key []byte) *models.Meter {
+	if key == nil {
+		return nil
+	}
+	data := tx.Bucket(bucketMeters).Get(key)
+	if data == nil {
+		return nil
+	}
+	var meter models.Meter
+	if err := json.Unmarshal(data, &meter); err != nil {
+		return nil
+	}
+	return &meter
+}
+
+func putMeter(tx *bolt.Tx, key []byte, meter *models.Meter) error {
+	data, err := json.Marshal(meter)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(bucketMeters).Put(key, data)
+}
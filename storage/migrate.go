@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// MigrationReport содержит итоги переноса файлов под новый шаблон раскладки
+type MigrationReport struct {
+	Moved        int // перенесено файлов
+	Unchanged    int // файлов уже на своем месте
+	MissingFiles int // записей индекса без файла на диске
+	Failed       int // файлов, которые не удалось перенести
+}
+
+// MigrateLayout переносит все файлы из индекса на места, заданные шаблоном fileManager,
+// и обновляет Path/FullPath в индексе. При dryRun файлы не трогаются, а только подсчитываются.
+// Индекс обновляется сразу после переноса каждого файла, поэтому прерванная миграция
+// не оставляет записей со старыми путями; повторный запуск продолжит с того же места.
+// Пока сервер запущен, база индекса заблокирована bbolt, и команда завершается ошибкой при открытии индекса.
+func MigrateLayout(indexer *Indexer, fileManager *FileManager, dryRun bool, logf func(format string, args ...interface{})) (MigrationReport, error) {
+	type entry struct {
+		counter string
+		photo   PhotoInfo
+	}
+
+	// Переносим по снимку индекса на начало миграции: записи, обновленные по ходу,
+	// не попадают в обход повторно
+	var entries []entry
+	indexer.ForEachPhoto(func(counterNumber string, photo *PhotoInfo) {
+		entries = append(entries, entry{counter: counterNumber, photo: *photo})
+	})
+
+	var report MigrationReport
+
+	for _, e := range entries {
+		if !fileManager.FileExists(e.photo.Path) {
+			report.MissingFiles++
+			logf("Missing file, skipped: %s", e.photo.Path)
+			continue
+		}
+
+		if dryRun {
+			target := fileManager.PlanRelocation(e.photo.Path, e.counter, e.photo.Date, e.photo.Hash)
+			if target == filepath.Clean(e.photo.Path) {
+				report.Unchanged++
+			} else {
+				report.Moved++
+				logf("Would move %s -> %s", e.photo.Path, target)
+			}
+			continue
+		}
+
+		newPath, err := fileManager.RelocateFile(e.photo.Path, e.counter, e.photo.Date, e.photo.Hash)
+		if err != nil {
+			report.Failed++
+			logf("Failed to move %s: %v", e.photo.Path, err)
+			continue
+		}
+		if newPath == e.photo.Path {
+			report.Unchanged++
+			continue
+		}
+
+		update := PathUpdate{
+			OldPath:     e.photo.Path,
+			NewPath:     newPath,
+			NewFullPath: filepath.Join(fileManager.BaseDir(), newPath),
+		}
+		if err := indexer.UpdatePaths([]PathUpdate{update}); err != nil {
+			// Возвращаем файл на старое место, чтобы индекс и диск не расходились
+			oldFullPath := filepath.Join(fileManager.BaseDir(), e.photo.Path)
+			if moveErr := moveNoReplace(update.NewFullPath, oldFullPath); moveErr != nil {
+				logf("Failed to move %s back to %s: %v", newPath, e.photo.Path, moveErr)
+			}
+			return report, fmt.Errorf("failed to update index: %w", err)
+		}
+
+		report.Moved++
+		logf("Moved %s -> %s", e.photo.Path, newPath)
+	}
+
+	return report, nil
+}
@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"photo-sync-server/metadata"
+	"photo-sync-server/models"
+)
+
+// ReadingMonthLayout - формат месяца в помесячных итогах
+const ReadingMonthLayout = "2006-01"
+
+// ReadingPoint - показание счетчика с одного фото
+type ReadingPoint struct {
+	Date   time.Time `json:"date"`
+	Value  float64   `json:"value"`
+	Delta  *float64  `json:"delta,omitempty"` // расход с предыдущего показания
+	Days   float64   `json:"days,omitempty"`  // дней с предыдущего показания
+	Source string    `json:"source,omitempty"`
+	Path   string    `json:"path"`
+	Hash   string    `json:"hash,omitempty"`
+
+	Anomalies []models.Anomaly `json:"anomalies,omitempty"`
+}
+
+// MonthlyConsumption - итог по показаниям за календарный месяц
+type MonthlyConsumption struct {
+	Month       string  `json:"month"` // ГГГГ-ММ
+	Readings    int     `json:"readings"`
+	First       float64 `json:"first"`
+	Last        float64 `json:"last"`
+	Consumption float64 `json:"consumption"` // сумма расходов показаний месяца
+}
+
+// RegisterHistory - показания одного регистра счетчика
+type RegisterHistory struct {
+	Register    string               `json:"register"`
+	Readings    []ReadingPoint       `json:"readings"`
+	Months      []MonthlyConsumption `json:"months"`
+	Consumption float64              `json:"consumption"` // расход с первого до последнего показания
+	Total       int                  `json:"total"`
+}
+
+// ReadingRegisters возвращает регистры, по которым в фото есть показания:
+// сначала metadata.DefaultRegister, затем остальные по алфавиту
+func ReadingRegisters(photos []*PhotoInfo) []string {
+	seen := make(map[string]bool)
+	for _, photo := range photos {
+		if photo.Reading != nil {
+			seen[metadata.DefaultRegister] = true
+		}
+		for register := range photo.Registers {
+			seen[register] = true
+		}
+	}
+
+	registers := make([]string, 0, len(seen))
+	for register := range seen {
+		registers = append(registers, register)
+	}
+	sort.Slice(registers, func(i, j int) bool {
+		if (registers[i] == metadata.DefaultRegister) != (registers[j] == metadata.DefaultRegister) {
+			return registers[i] == metadata.DefaultRegister
+		}
+		return registers[i] < registers[j]
+	})
+	return registers
+}
+
+// ReadingHistory считает историю показаний по каждому регистру счетчика
+func ReadingHistory(photos []*PhotoInfo) []RegisterHistory {
+	registers := ReadingRegisters(photos)
+	history := make([]RegisterHistory, 0, len(registers))
+	for _, register := range registers {
+		points := ReadingSeries(photos, register)
+		history = append(history, RegisterHistory{
+			Register:    register,
+			Readings:    points,
+			Months:      MonthlyReadings(points),
+			Consumption: Consumption(points),
+			Total:       len(points),
+		})
+	}
+	return history
+}
+
+// ReadingSeries собирает показания регистра из фото по возрастанию даты и считает
+// расход между соседними показаниями. Фото без показания регистра пропускаются.
+func ReadingSeries(photos []*PhotoInfo, register string) []ReadingPoint {
+	points := make([]ReadingPoint, 0, len(photos))
+	for _, photo := range photos {
+		value, ok := photo.RegisterReading(register)
+		if !ok {
+			continue
+		}
+		points = append(points, ReadingPoint{
+			Date:   photo.Date,
+			Value:  value,
+			Source: photo.ReadingSource,
+			Path:   photo.Path,
+			Hash:   photo.Hash,
+
+			Anomalies: registerAnomalies(photo.Anomalies, register),
+		})
+	}
+
+	sort.SliceStable(points, func(i, j int) bool {
+		if points[i].Date.Equal(points[j].Date) {
+			return points[i].Path < points[j].Path
+		}
+		return points[i].Date.Before(points[j].Date)
+	})
+
+	for i := 1; i < len(points); i++ {
+		delta := roundReading(points[i].Value - points[i-1].Value)
+		points[i].Delta = &delta
+		points[i].Days = math.Round(points[i].Date.Sub(points[i-1].Date).Hours()/24*100) / 100
+	}
+	return points
+}
+
+// MonthlyReadings группирует ряд показаний по месяцам. Расход между показаниями
+// относится к месяцу более позднего показания.
+func MonthlyReadings(points []ReadingPoint) []MonthlyConsumption {
+	months := make([]MonthlyConsumption, 0)
+	for _, point := range points {
+		month := point.Date.Format(ReadingMonthLayout)
+		if len(months) == 0 || months[len(months)-1].Month != month {
+			months = append(months, MonthlyConsumption{Month: month, First: point.Value})
+		}
+		current := &months[len(months)-1]
+		current.Readings++
+		current.Last = point.Value
+		if point.Delta != nil {
+			current.Consumption = roundReading(current.Consumption + *point.Delta)
+		}
+	}
+	return months
+}
+
+// Consumption возвращает расход за весь ряд показаний
+func Consumption(points []ReadingPoint) float64 {
+	if len(points) < 2 {
+		return 0
+	}
+	return roundReading(points[len(points)-1].Value - points[0].Value)
+}
+
+// roundReading убирает погрешность float64 при вычитании показаний (0.30000000000000004)
+func roundReading(value float64) float64 {
+	return math.Round(value*1e6) / 1e6
+}
+
+// registerAnomalies оставляет отметки, относящиеся к регистру
+func registerAnomalies(anomalies []models.Anomaly, register string) []models.Anomaly {
+	var result []models.Anomaly
+	for _, anomaly := range anomalies {
+		if anomalyRegister(anomaly) == register {
+			result = append(result, anomaly)
+		}
+	}
+	return result
+}
@@ -1,37 +1,86 @@
 package storage
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
 	"photo-sync-server/models"
 )
 
-// SessionStore хранит активные сессии синхронизации
+// receivedLogSuffix - окончание имени журнала принятых файлов сессии ({token}.received.jsonl)
+const receivedLogSuffix = ".received.jsonl"
+
+// subscriberBuffer - сколько событий копится для медленного подписчика,
+// прежде чем старые события начнут отбрасываться
+const subscriberBuffer = 64
+
+// SessionEvent описывает изменение сессии для подписчиков
+type SessionEvent struct {
+	Session models.Session     // снимок сессии после изменения
+	Result  *models.FileResult // результат обработки файла, если изменение вызвано им
+}
+
+// SessionStore хранит активные сессии синхронизации.
+// Каждая сессия сохраняется в файл {token}.json при каждом изменении,
+// поэтому после перезапуска сервера устройство продолжает работу с тем же токеном.
+// Список принятых файлов растет с каждым фото, поэтому он не переписывается целиком,
+// а дописывается в журнал {token}.received.jsonl по строке на файл.
 type SessionStore struct {
-	sessions map[string]*models.Session
-	mu       sync.RWMutex
+	dir         string
+	sessions    map[string]*models.Session
+	subscribers map[string]map[chan SessionEvent]struct{}
+	logged      map[string]int // сколько записей Received сессии уже в журнале
+	ttl         time.Duration
+	mu          sync.RWMutex
 }
 
-// NewSessionStore создает новое хранилище сессий
-func NewSessionStore() *SessionStore {
+// NewSessionStore создает хранилище сессий в указанной директории и загружает сохраненные сессии.
+// Неактивные дольше ttl сессии удаляются.
+func NewSessionStore(dir string, ttl time.Duration) (*SessionStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create sessions directory: %w", err)
+	}
+
 	store := &SessionStore{
-		sessions: make(map[string]*models.Session),
+		dir:         dir,
+		sessions:    make(map[string]*models.Session),
+		subscribers: make(map[string]map[chan SessionEvent]struct{}),
+		logged:      make(map[string]int),
+		ttl:         ttl,
 	}
 
+	// Восстанавливаем сессии, прерванные перезапуском сервера
+	store.load()
+
 	// Запускаем очистку старых сессий каждую минуту
 	go store.cleanup()
 
-	return store
+	return store, nil
+}
+
+// Count возвращает количество активных сессий
+func (s *SessionStore) Count() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.sessions)
 }
 
-// Create создает новую сессию
-func (s *SessionStore) Create(token string) *models.Session {
+// Create создает новую сессию с секретом для подписи запросов устройства
+func (s *SessionStore) Create(token string, secret string) *models.Session {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	session := models.NewSession(token)
+	session := models.NewSession(token, secret)
 	s.sessions[token] = session
+	s.saveLocked(session)
 	return session
 }
 
@@ -44,7 +93,8 @@ func (s *SessionStore) Get(token string) (*models.Session, bool) {
 	return session, exists
 }
 
-// Update обновляет сессию
+// Update обновляет сессию и уведомляет подписчиков.
+// Если updater записал новый LastResult, он передается в событии как Result.
 func (s *SessionStore) Update(token string, updater func(*models.Session)) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -54,20 +104,237 @@ func (s *SessionStore) Update(token string, updater func(*models.Session)) bool
 		return false
 	}
 
+	lastResult := session.LastResult
 	updater(session)
 	session.Update()
+	s.saveLocked(session)
+
+	event := SessionEvent{Session: snapshotSession(session)}
+	if session.LastResult != lastResult {
+		event.Result = session.LastResult
+	}
+	s.publishLocked(token, event)
 	return true
 }
 
+// Subscribe подписывает на изменения сессии.
+// Канал закрывается при удалении сессии или вызове cancel.
+func (s *SessionStore) Subscribe(token string) (<-chan SessionEvent, func(), bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.sessions[token]; !exists {
+		return nil, nil, false
+	}
+
+	ch := make(chan SessionEvent, subscriberBuffer)
+	if s.subscribers[token] == nil {
+		s.subscribers[token] = make(map[chan SessionEvent]struct{})
+	}
+	s.subscribers[token][ch] = struct{}{}
+
+	cancel := func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if _, exists := s.subscribers[token][ch]; exists {
+			delete(s.subscribers[token], ch)
+			close(ch)
+		}
+	}
+	return ch, cancel, true
+}
+
+// Snapshot возвращает копию сессии, которую можно читать без блокировки
+func (s *SessionStore) Snapshot(token string) (models.Session, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, exists := s.sessions[token]
+	if !exists {
+		return models.Session{}, false
+	}
+	return snapshotSession(session), true
+}
+
 // Delete удаляет сессию
 func (s *SessionStore) Delete(token string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	s.deleteLocked(token)
+}
+
+// deleteLocked удаляет сессию и закрывает каналы ее подписчиков (вызывается под блокировкой)
+func (s *SessionStore) deleteLocked(token string) {
 	delete(s.sessions, token)
+	delete(s.logged, token)
+	os.Remove(s.sessionPath(token))
+	os.Remove(s.receivedLogPath(token))
+	for ch := range s.subscribers[token] {
+		close(ch)
+	}
+	delete(s.subscribers, token)
+}
+
+// publishLocked рассылает событие подписчикам сессии (вызывается под блокировкой).
+// Запись не блокируется: если подписчик не успевает читать, самое старое событие отбрасывается.
+func (s *SessionStore) publishLocked(token string, event SessionEvent) {
+	for ch := range s.subscribers[token] {
+		select {
+		case ch <- event:
+			continue
+		default:
+		}
+		select {
+		case <-ch:
+		default:
+		}
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// load загружает сохраненные сессии и удаляет истекшие
+func (s *SessionStore) load() {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		fmt.Printf("Warning: Failed to read sessions directory: %v\n", err)
+		return
+	}
+
+	now := time.Now()
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(s.dir, entry.Name())
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+
+		var session models.Session
+		if err := json.Unmarshal(data, &session); err != nil || session.Token == "" {
+			fmt.Printf("Warning: Failed to parse session %s: %v\n", entry.Name(), err)
+			continue
+		}
+
+		if now.Sub(session.LastUpdate) > s.ttl {
+			os.Remove(path)
+			os.Remove(s.receivedLogPath(session.Token))
+			continue
+		}
+		// Файлы сессий прежних версий хранят Received целиком: они попадут в журнал при следующем сохранении
+		if received := s.loadReceived(session.Token); len(received) > 0 || len(session.Received) == 0 {
+			session.Received = received
+			s.logged[session.Token] = len(received)
+		}
+		s.sessions[session.Token] = &session
+	}
+}
+
+// loadReceived читает журнал принятых файлов сессии. Строка, недописанная при сбое, пропускается.
+func (s *SessionStore) loadReceived(token string) []models.FileResult {
+	file, err := os.Open(s.receivedLogPath(token))
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var received []models.FileResult
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var result models.FileResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			continue
+		}
+		received = append(received, result)
+	}
+	return received
+}
+
+// saveLocked сохраняет сессию на диск (вызывается под блокировкой).
+// Ошибка записи не прерывает синхронизацию: сессия остается в памяти.
+func (s *SessionStore) saveLocked(session *models.Session) {
+	s.appendReceivedLocked(session)
+
+	// Received хранится в журнале, в файле сессии только счетчики и состояние
+	state := *session
+	state.Received = nil
+	data, err := json.Marshal(&state)
+	if err != nil {
+		fmt.Printf("Warning: Failed to marshal session %s: %v\n", session.Token, err)
+		return
+	}
+
+	// В файле лежит секрет сессии, поэтому он доступен только владельцу
+	path := s.sessionPath(session.Token)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		fmt.Printf("Warning: Failed to save session %s: %v\n", session.Token, err)
+		return
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		fmt.Printf("Warning: Failed to save session %s: %v\n", session.Token, err)
+	}
+}
+
+// appendReceivedLocked дописывает в журнал записи Received, которых в нем еще нет (вызывается под блокировкой)
+func (s *SessionStore) appendReceivedLocked(session *models.Session) {
+	logged := s.logged[session.Token]
+	if logged >= len(session.Received) {
+		return
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, result := range session.Received[logged:] {
+		if err := encoder.Encode(result); err != nil {
+			fmt.Printf("Warning: Failed to marshal session %s result: %v\n", session.Token, err)
+			return
+		}
+	}
+
+	file, err := os.OpenFile(s.receivedLogPath(session.Token), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		fmt.Printf("Warning: Failed to save session %s results: %v\n", session.Token, err)
+		return
+	}
+	_, err = file.Write(buf.Bytes())
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Printf("Warning: Failed to save session %s results: %v\n", session.Token, err)
+		return
+	}
+	s.logged[session.Token] = len(session.Received)
+}
+
+func (s *SessionStore) sessionPath(token string) string {
+	return filepath.Join(s.dir, token+".json")
+}
+
+func (s *SessionStore) receivedLogPath(token string) string {
+	return filepath.Join(s.dir, token+receivedLogSuffix)
+}
+
+// snapshotSession копирует сессию вместе со списком ошибок.
+// Список Received только дополняется, поэтому копия может ссылаться на тот же массив.
+func snapshotSession(session *models.Session) models.Session {
+	snapshot := *session
+	snapshot.Errors = append([]string(nil), session.Errors...)
+	snapshot.Received = session.Received[:len(session.Received):len(session.Received)]
+	return snapshot
 }
 
-// cleanup удаляет сессии старше 1 часа
+// cleanup удаляет сессии, неактивные дольше ttl
 func (s *SessionStore) cleanup() {
 	ticker := time.NewTicker(1 * time.Minute)
 	defer ticker.Stop()
@@ -76,8 +343,8 @@ func (s *SessionStore) cleanup() {
 		s.mu.Lock()
 		now := time.Now()
 		for token, session := range s.sessions {
-			if now.Sub(session.LastUpdate) > 1*time.Hour {
-				delete(s.sessions, token)
+			if now.Sub(session.LastUpdate) > s.ttl {
+				s.deleteLocked(token)
 			}
 		}
 		s.mu.Unlock()
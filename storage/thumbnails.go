@@ -0,0 +1,298 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"photo-sync-server/metadata"
+)
+
+// ThumbnailSizes - размеры миниатюр по длинной стороне в пикселях
+var ThumbnailSizes = []int{256, 1024}
+
+// thumbnailQuality - качество JPEG миниатюр
+const thumbnailQuality = 82
+
+// maxThumbnailWorkers ограничивает одновременное создание миниатюр:
+// декодированное фото 4000x3000 занимает в памяти около 50 МБ
+const maxThumbnailWorkers = 2
+
+// maxThumbnailPixels ограничивает размер фото, для которого создаются миниатюры.
+// Декодирование занимает около 7 байт на пиксель (YCbCr и RGBA), 50 Мп - примерно 350 МБ;
+// фото больше (или файл с поддельными размерами в заголовке) пропускается без декодирования.
+const maxThumbnailPixels = 50_000_000
+
+// ErrUnsupportedImage возвращается, если формат фото не поддерживается для миниатюр
+var ErrUnsupportedImage = errors.New("unsupported image format")
+
+// ThumbnailCache создает и хранит JPEG миниатюры фото.
+// Миниатюры лежат в {dir}/{size}/{hash[:2]}/{hash}.jpg, поэтому не зависят от раскладки фото.
+type ThumbnailCache struct {
+	dir     string
+	workers chan struct{}
+	mu      sync.Mutex
+	pending map[string]*sync.WaitGroup
+}
+
+// NewThumbnailCache создает кеш миниатюр в указанной директории
+func NewThumbnailCache(dir string) (*ThumbnailCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create thumbnails directory: %w", err)
+	}
+	return &ThumbnailCache{
+		dir:     dir,
+		workers: make(chan struct{}, maxThumbnailWorkers),
+		pending: make(map[string]*sync.WaitGroup),
+	}, nil
+}
+
+// Dir возвращает директорию кеша
+func (tc *ThumbnailCache) Dir() string {
+	return tc.dir
+}
+
+// FitSize возвращает ближайший размер миниатюры не меньше запрошенного
+func FitSize(requested int) int {
+	for _, size := range ThumbnailSizes {
+		if requested <= size {
+			return size
+		}
+	}
+	return ThumbnailSizes[len(ThumbnailSizes)-1]
+}
+
+// Get возвращает путь к миниатюре фото, при необходимости создавая ее из srcPath
+func (tc *ThumbnailCache) Get(hash string, size int, srcPath string) (string, error) {
+	path := tc.thumbPath(hash, size)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	if err := tc.Generate(hash, srcPath); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// GenerateAsync создает миниатюры в фоне, не задерживая прием фото
+func (tc *ThumbnailCache) GenerateAsync(hash string, srcPath string) {
+	go func() {
+		if err := tc.Generate(hash, srcPath); err != nil && !errors.Is(err, ErrUnsupportedImage) {
+			fmt.Printf("Warning: Failed to generate thumbnails for %s: %v\n", srcPath, err)
+		}
+	}()
+}
+
+// Generate создает миниатюры всех размеров для фото.
+// Параллельные запросы одного и того же фото ждут единственной генерации.
+func (tc *ThumbnailCache) Generate(hash string, srcPath string) error {
+	if len(hash) < 2 {
+		return fmt.Errorf("invalid photo hash")
+	}
+
+	tc.mu.Lock()
+	if wg, busy := tc.pending[hash]; busy {
+		tc.mu.Unlock()
+		wg.Wait()
+		if _, err := os.Stat(tc.thumbPath(hash, ThumbnailSizes[0])); err != nil {
+			return fmt.Errorf("failed to generate thumbnails: %w", err)
+		}
+		return nil
+	}
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	tc.pending[hash] = wg
+	tc.mu.Unlock()
+
+	defer func() {
+		tc.mu.Lock()
+		delete(tc.pending, hash)
+		tc.mu.Unlock()
+		wg.Done()
+	}()
+
+	tc.workers <- struct{}{}
+	defer func() { <-tc.workers }()
+
+	return tc.generate(hash, srcPath)
+}
+
+// Clear удаляет все миниатюры
+func (tc *ThumbnailCache) Clear() error {
+	for _, size := range ThumbnailSizes {
+		if err := os.RemoveAll(filepath.Join(tc.dir, strconv.Itoa(size))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generate декодирует фото один раз и сохраняет миниатюры всех размеров
+func (tc *ThumbnailCache) generate(hash string, srcPath string) error {
+	data, err := os.ReadFile(srcPath)
+	if err != nil {
+		return err
+	}
+
+	// Размеры читаются из заголовка до декодирования, чтобы не выделять память под огромное фото
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnsupportedImage, err)
+	}
+	if int64(config.Width)*int64(config.Height) > maxThumbnailPixels {
+		return fmt.Errorf("%w: %dx%d exceeds %d pixels", ErrUnsupportedImage, config.Width, config.Height, maxThumbnailPixels)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnsupportedImage, err)
+	}
+	orientation := metadata.ReadOrientation(data)
+
+	src := toRGBA(img)
+	for _, size := range ThumbnailSizes {
+		thumb := applyOrientation(downscale(src, size), orientation)
+		if err := tc.save(tc.thumbPath(hash, size), thumb); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// save атомарно записывает миниатюру в кеш
+func (tc *ThumbnailCache) save(path string, img image.Image) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create thumbnails directory: %w", err)
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), ".thumb-*")
+	if err != nil {
+		return fmt.Errorf("failed to create thumbnail: %w", err)
+	}
+	tmpPath := file.Name()
+
+	err = jpeg.Encode(file, img, &jpeg.Options{Quality: thumbnailQuality})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to save thumbnail: %w", err)
+	}
+	return nil
+}
+
+func (tc *ThumbnailCache) thumbPath(hash string, size int) string {
+	return filepath.Join(tc.dir, strconv.Itoa(size), hash[:2], hash+".jpg")
+}
+
+// toRGBA приводит изображение к RGBA, чтобы работать с пикселями напрямую
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Rect.Min == (image.Point{}) {
+		return rgba
+	}
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Rect, img, bounds.Min, draw.Src)
+	return rgba
+}
+
+// downscale уменьшает изображение так, чтобы длинная сторона была не больше size.
+// Каждый пиксель результата - среднее по соответствующему прямоугольнику исходника,
+// это дает чистую картинку без муара даже при сильном уменьшении.
+func downscale(src *image.RGBA, size int) *image.RGBA {
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	if sw <= size && sh <= size {
+		return src
+	}
+
+	dw, dh := size, size
+	if sw >= sh {
+		dh = max(1, sh*size/sw)
+	} else {
+		dw = max(1, sw*size/sh)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*sh/dh, (y+1)*sh/dh
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*sw/dw, (x+1)*sw/dw
+
+			var r, g, b, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				offset := sy*src.Stride + x0*4
+				for sx := x0; sx < x1; sx++ {
+					r += uint32(src.Pix[offset])
+					g += uint32(src.Pix[offset+1])
+					b += uint32(src.Pix[offset+2])
+					a += uint32(src.Pix[offset+3])
+					offset += 4
+					n++
+				}
+			}
+
+			i := y*dst.Stride + x*4
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+// applyOrientation поворачивает и отражает изображение согласно тегу EXIF Orientation
+func applyOrientation(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 {
+		return src
+	}
+
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // отражение по горизонтали
+				dx, dy = w-1-x, y
+			case 3: // поворот на 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // отражение по вертикали
+				dx, dy = x, h-1-y
+			case 5: // транспонирование
+				dx, dy = y, x
+			case 6: // поворот на 90 по часовой
+				dx, dy = h-1-y, x
+			case 7: // поперечное отражение
+				dx, dy = h-1-y, w-1-x
+			case 8: // поворот на 90 против часовой
+				dx, dy = y, w-1-x
+			}
+			si := y*src.Stride + x*4
+			di := dy*dst.Stride + dx*4
+			copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,393 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrUploadNotFound возвращается, если загрузка не найдена или уже удалена
+	ErrUploadNotFound = errors.New("upload not found")
+	// ErrOffsetMismatch возвращается, если смещение клиента не совпадает с сервером
+	ErrOffsetMismatch = errors.New("upload offset mismatch")
+	// ErrUploadBusy возвращается, если в загрузку уже пишет другой запрос
+	ErrUploadBusy = errors.New("upload is busy")
+	// ErrChecksumMismatch возвращается, если хеш фрагмента не совпал с заявленным
+	ErrChecksumMismatch = errors.New("upload chunk checksum mismatch")
+	// ErrUploadFinished возвращается, если загрузка уже завершена и фото принято
+	ErrUploadFinished = errors.New("upload is already finished")
+)
+
+// Upload описывает возобновляемую загрузку одного фото
+type Upload struct {
+	ID            string            `json:"id"`
+	Token         string            `json:"token"`
+	Length        int64             `json:"length"`
+	Offset        int64             `json:"offset"`
+	CounterNumber string            `json:"counterNumber,omitempty"`
+	OriginalName  string            `json:"originalName,omitempty"`
+	DateTaken     string            `json:"dateTaken,omitempty"`
+	Reading       string            `json:"reading,omitempty"`
+	Registers     map[string]string `json:"registers,omitempty"` // показания по регистрам из ключей reading.<регистр>
+	Hash          string            `json:"hash,omitempty"`      // SHA-256 полного файла, известен перед приемом в конвейер
+	CreatedAt     time.Time         `json:"createdAt"`
+	LastUpdate    time.Time         `json:"lastUpdate"`
+
+	// Ответ на завершающий запрос; хранится до истечения ttl, чтобы повтор запроса
+	// получил тот же ответ, а не принял фото еще раз
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// Complete возвращает true, если все данные загрузки получены
+func (u *Upload) Complete() bool {
+	return u.Offset >= u.Length
+}
+
+// Finished возвращает true, если загрузка завершена и фото прошло конвейер приема
+func (u *Upload) Finished() bool {
+	return u.Result != nil
+}
+
+// UploadStore хранит частично загруженные файлы на диске.
+// Для каждой загрузки создаются два файла: {id}.part с данными и {id}.json с состоянием,
+// поэтому загрузку можно продолжить и после перезапуска сервера.
+type UploadStore struct {
+	dir     string
+	ttl     time.Duration
+	uploads map[string]*Upload
+	busy    map[string]bool
+	mu      sync.Mutex
+}
+
+// NewUploadStore создает хранилище загрузок в указанной директории
+// и удаляет загрузки, не обновлявшиеся дольше ttl
+func NewUploadStore(dir string, ttl time.Duration) (*UploadStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create uploads directory: %w", err)
+	}
+
+	store := &UploadStore{
+		dir:     dir,
+		ttl:     ttl,
+		uploads: make(map[string]*Upload),
+		busy:    make(map[string]bool),
+	}
+
+	// Подхватываем незавершенные загрузки, оставшиеся с прошлого запуска
+	store.load()
+
+	// Запускаем очистку брошенных загрузок каждую минуту
+	go store.cleanup()
+
+	return store, nil
+}
+
+// Create создает новую загрузку для сессии
+func (s *UploadStore) Create(token string, length int64, counterNumber, originalName, dateTaken, reading string, registers map[string]string) (*Upload, error) {
+	id, err := generateUploadID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	upload := &Upload{
+		ID:            id,
+		Token:         token,
+		Length:        length,
+		CounterNumber: counterNumber,
+		OriginalName:  originalName,
+		DateTaken:     dateTaken,
+		Reading:       reading,
+		Registers:     registers,
+		CreatedAt:     now,
+		LastUpdate:    now,
+	}
+
+	file, err := os.Create(s.partPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload file: %w", err)
+	}
+	file.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.saveMeta(upload); err != nil {
+		os.Remove(s.partPath(id))
+		return nil, err
+	}
+	s.uploads[id] = upload
+
+	copied := *upload
+	return &copied, nil
+}
+
+// Get возвращает копию состояния загрузки
+func (s *UploadStore) Get(id string) (*Upload, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upload, exists := s.uploads[id]
+	if !exists {
+		return nil, false
+	}
+	copied := *upload
+	return &copied, true
+}
+
+// WriteChunk дописывает данные из r начиная с offset.
+// Если соединение оборвалось посреди запроса, полученная часть все равно сохраняется,
+// и клиент продолжит с нового смещения, которое вернет HEAD.
+// Если задан expectedSHA256, фрагмент принимается только целиком и с совпадающим хешем.
+// Если после записи получены все данные, загрузка остается занятой до вызова Finish или Release.
+// Для завершенной загрузки возвращается ErrUploadFinished вместе с ее состоянием.
+func (s *UploadStore) WriteChunk(id string, offset int64, r io.Reader, expectedSHA256 string) (*Upload, error) {
+	s.mu.Lock()
+	upload, exists := s.uploads[id]
+	if !exists {
+		s.mu.Unlock()
+		return nil, ErrUploadNotFound
+	}
+	if s.busy[id] {
+		s.mu.Unlock()
+		return nil, ErrUploadBusy
+	}
+	if upload.Finished() {
+		copied := *upload
+		s.mu.Unlock()
+		return &copied, ErrUploadFinished
+	}
+	if upload.Offset != offset {
+		s.mu.Unlock()
+		return nil, ErrOffsetMismatch
+	}
+	s.busy[id] = true
+	if upload.Complete() {
+		// Повтор завершающего запроса после неудачного приема: данных больше не ждем,
+		// файла может уже не быть, если конвейер забрал его перед сбоем
+		copied := *upload
+		s.mu.Unlock()
+		return &copied, nil
+	}
+	remaining := upload.Length - upload.Offset
+	s.mu.Unlock()
+
+	hash := sha256.New()
+	written, writeErr := s.appendPart(id, offset, io.TeeReader(r, hash), remaining)
+	if expectedSHA256 != "" && (writeErr != nil || hex.EncodeToString(hash.Sum(nil)) != expectedSHA256) {
+		// Непроверенные данные не засчитываются: следующая запись начнется с прежнего смещения
+		written = 0
+		if writeErr == nil {
+			writeErr = ErrChecksumMismatch
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upload.Offset += written
+	upload.LastUpdate = time.Now()
+	if err := s.saveMeta(upload); err != nil && writeErr == nil {
+		writeErr = err
+	}
+	if writeErr != nil || !upload.Complete() {
+		delete(s.busy, id)
+	}
+
+	copied := *upload
+	return &copied, writeErr
+}
+
+// PartPath возвращает путь к файлу с полученными данными загрузки
+func (s *UploadStore) PartPath(id string) string {
+	return s.partPath(id)
+}
+
+// Finish отмечает загрузку завершенной и сохраняет ответ на завершающий запрос.
+// Файл данных к этому моменту уже забран конвейером приема.
+func (s *UploadStore) Finish(id string, result []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.busy, id)
+	upload, exists := s.uploads[id]
+	if !exists {
+		return ErrUploadNotFound
+	}
+	os.Remove(s.partPath(id))
+	upload.Result = result
+	upload.LastUpdate = time.Now()
+	return s.saveMeta(upload)
+}
+
+// SetHash запоминает хеш собранного файла до передачи его в конвейер приема,
+// чтобы после сбоя повтор завершающего запроса нашел уже принятое фото в индексе
+func (s *UploadStore) SetHash(id string, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upload, exists := s.uploads[id]
+	if !exists {
+		return ErrUploadNotFound
+	}
+	upload.Hash = hash
+	return s.saveMeta(upload)
+}
+
+// Release снимает занятость с загрузки, завершение которой не удалось, чтобы клиент мог повторить запрос
+func (s *UploadStore) Release(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.busy, id)
+}
+
+// Remove удаляет загрузку и ее данные
+func (s *UploadStore) Remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.removeLocked(id)
+}
+
+// appendPart дописывает не более limit байт в файл загрузки
+func (s *UploadStore) appendPart(id string, offset int64, r io.Reader, limit int64) (int64, error) {
+	file, err := os.OpenFile(s.partPath(id), os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open upload file: %w", err)
+	}
+	defer file.Close()
+
+	// Отбрасываем хвост, который мог остаться от прерванной записи без сохраненного состояния
+	if err := file.Truncate(offset); err != nil {
+		return 0, fmt.Errorf("failed to truncate upload file: %w", err)
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to seek upload file: %w", err)
+	}
+
+	written, copyErr := io.Copy(file, io.LimitReader(r, limit))
+	if err := file.Sync(); err != nil && copyErr == nil {
+		copyErr = fmt.Errorf("failed to sync upload file: %w", err)
+	}
+	return written, copyErr
+}
+
+// load загружает состояние незавершенных загрузок с диска
+func (s *UploadStore) load() {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		fmt.Printf("Warning: Failed to read uploads directory: %v\n", err)
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+
+		var upload Upload
+		if err := json.Unmarshal(data, &upload); err != nil || upload.ID == "" {
+			fmt.Printf("Warning: Failed to parse upload state %s: %v\n", entry.Name(), err)
+			continue
+		}
+
+		// У завершенной загрузки данных уже нет, хранится только ответ
+		if upload.Finished() {
+			s.uploads[upload.ID] = &upload
+			continue
+		}
+
+		// Смещение не может быть больше реально записанных данных
+		info, err := os.Stat(s.partPath(upload.ID))
+		if err != nil {
+			// Файл собранной загрузки забран конвейером перед сбоем: по хешу
+			// повтор завершающего запроса найдет принятое фото в индексе
+			if upload.Complete() && upload.Hash != "" {
+				s.uploads[upload.ID] = &upload
+				continue
+			}
+			os.Remove(s.metaPath(upload.ID))
+			continue
+		}
+		if info.Size() < upload.Offset {
+			upload.Offset = info.Size()
+		}
+
+		s.uploads[upload.ID] = &upload
+	}
+}
+
+// saveMeta сохраняет состояние загрузки (вызывается под блокировкой)
+func (s *UploadStore) saveMeta(upload *Upload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal upload state: %w", err)
+	}
+
+	tmpPath := s.metaPath(upload.ID) + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to save upload state: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.metaPath(upload.ID)); err != nil {
+		return fmt.Errorf("failed to save upload state: %w", err)
+	}
+	return nil
+}
+
+// removeLocked удаляет загрузку (вызывается под блокировкой)
+func (s *UploadStore) removeLocked(id string) {
+	delete(s.uploads, id)
+	delete(s.busy, id)
+	os.Remove(s.partPath(id))
+	os.Remove(s.metaPath(id))
+}
+
+// cleanup удаляет загрузки, брошенные дольше ttl
+func (s *UploadStore) cleanup() {
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.mu.Lock()
+		now := time.Now()
+		for id, upload := range s.uploads {
+			if !s.busy[id] && now.Sub(upload.LastUpdate) > s.ttl {
+				s.removeLocked(id)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *UploadStore) partPath(id string) string {
+	return filepath.Join(s.dir, id+".part")
+}
+
+func (s *UploadStore) metaPath(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// generateUploadID генерирует случайный идентификатор загрузки
+func generateUploadID() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate upload id: %w", err)
+	}
+	return hex.EncodeToString(bytes), nil
+}
@@ -0,0 +1,318 @@
+// Галерея фото счетчиков. Работает без интернета: все данные берутся с этого сервера.
+(function () {
+  'use strict';
+
+  // Ключ администратора нужен только при открытии галереи с другого компьютера.
+  // Он вводится в форме один раз: сервер отвечает HttpOnly cookie, и дальше ключ
+  // не передается ни в адресах фото, ни в скриптах. Ключ прежних версий убираем из браузера.
+  localStorage.removeItem('photoSyncAdminKey');
+
+  var countersEl = document.getElementById('counters');
+  var filterEl = document.getElementById('filter');
+  var timelineEl = document.getElementById('timeline');
+  var summaryEl = document.getElementById('summary');
+  var viewerEl = document.getElementById('viewer');
+  var viewerImage = document.getElementById('viewer-image');
+  var viewerCaption = document.getElementById('viewer-caption');
+
+  var counters = [];
+  var currentPhotos = [];
+  var viewerIndex = -1;
+
+  function api(url) {
+    return fetch(url, { credentials: 'same-origin' }).then(function (resp) {
+      if (resp.status === 401) {
+        showLogin();
+        throw new Error('admin key is required');
+      }
+      if (!resp.ok) {
+        throw new Error('HTTP ' + resp.status);
+      }
+      return resp.json();
+    });
+  }
+
+  // photoURL собирает адрес файла; пути из индекса на Windows содержат обратные слеши
+  function photoURL(path) {
+    var parts = path.replace(/\\/g, '/').split('/').map(encodeURIComponent);
+    return '/photos/by-path/' + parts.join('/');
+  }
+
+  // thumbURL - адрес миниатюры; для фото без хеша в индексе используется оригинал
+  function thumbURL(photo, size) {
+    if (!photo.hash) {
+      return photoURL(photo.path);
+    }
+    return '/photos/' + photo.hash + '/thumb?size=' + size;
+  }
+
+  // showImage загружает миниатюру, а если ее нельзя создать (например, не JPEG/PNG) - оригинал
+  function showImage(img, photo, size) {
+    img.onerror = function () {
+      img.onerror = null;
+      img.src = photoURL(photo.path);
+    };
+    img.src = thumbURL(photo, size);
+  }
+
+  function formatSize(bytes) {
+    if (bytes >= 1024 * 1024) {
+      return (bytes / 1024 / 1024).toFixed(1) + ' МБ';
+    }
+    return Math.max(1, Math.round(bytes / 1024)) + ' КБ';
+  }
+
+  function formatDate(value) {
+    var date = new Date(value);
+    return date.toLocaleDateString('ru-RU') + ' ' + date.toLocaleTimeString('ru-RU', { hour: '2-digit', minute: '2-digit' });
+  }
+
+  // readingText описывает поля структурированного комментария: показания, квартиру, контролера
+  function readingText(photo) {
+    var parts = [];
+    if (photo.reading !== undefined) {
+      parts.push('показание ' + photo.reading.toLocaleString('ru-RU'));
+    }
+    Object.keys(photo.registers || {}).sort().forEach(function (register) {
+      parts.push(register.toUpperCase() + ' ' + photo.registers[register].toLocaleString('ru-RU'));
+    });
+    if (photo.apartment) {
+      parts.push('кв. ' + photo.apartment);
+    }
+    if (photo.inspectorId) {
+      parts.push('контролер ' + photo.inspectorId);
+    }
+    return parts.join(', ');
+  }
+
+  var anomalyNames = {
+    rollback: 'показание меньше предыдущего',
+    outlier: 'необычный расход',
+    repeated: 'повтор показания'
+  };
+
+  // anomalyText перечисляет отметки о подозрительном показании
+  function anomalyText(photo) {
+    return (photo.anomalies || []).map(function (anomaly) {
+      var name = anomalyNames[anomaly.type] || anomaly.type;
+      return anomaly.register ? anomaly.register.toUpperCase() + ': ' + name : name;
+    }).join(', ');
+  }
+
+  function monthTitle(value) {
+    return new Date(value).toLocaleDateString('ru-RU', { month: 'long', year: 'numeric' });
+  }
+
+  function element(tag, className, text) {
+    var el = document.createElement(tag);
+    if (className) {
+      el.className = className;
+    }
+    if (text !== undefined) {
+      el.textContent = text;
+    }
+    return el;
+  }
+
+  function loadCounters() {
+    api('/index/counters').then(function (data) {
+      counters = data.counters || [];
+      var photos = counters.reduce(function (sum, c) { return sum + c.photos; }, 0);
+      summaryEl.textContent = 'Счетчиков: ' + counters.length + ', фото: ' + photos;
+      renderCounters();
+
+      var selected = location.hash.slice(1);
+      if (selected) {
+        selectCounter(decodeURIComponent(selected));
+      }
+    }).catch(showError);
+  }
+
+  function renderCounters() {
+    var filter = filterEl.value.trim().toLowerCase();
+    countersEl.innerHTML = '';
+    counters.forEach(function (counter) {
+      if (filter && counter.counterNumber.toLowerCase().indexOf(filter) < 0) {
+        return;
+      }
+      var li = element('li');
+      li.dataset.counter = counter.counterNumber;
+      li.appendChild(element('div', 'number', counter.counterNumber));
+      li.appendChild(element('div', 'meta', counter.photos + ' фото, последнее ' + formatDate(counter.lastDate)));
+      li.addEventListener('click', function () {
+        location.hash = encodeURIComponent(counter.counterNumber);
+      });
+      countersEl.appendChild(li);
+    });
+    markActive();
+  }
+
+  function markActive() {
+    var selected = decodeURIComponent(location.hash.slice(1));
+    Array.prototype.forEach.call(countersEl.children, function (li) {
+      li.classList.toggle('active', li.dataset.counter === selected);
+    });
+  }
+
+  function selectCounter(counterNumber) {
+    markActive();
+    api('/index?counterNumber=' + encodeURIComponent(counterNumber)).then(function (data) {
+      currentPhotos = (data.photos || []).slice().sort(function (a, b) {
+        return new Date(b.date) - new Date(a.date);
+      });
+      renderTimeline(counterNumber);
+    }).catch(showError);
+  }
+
+  // renderTimeline показывает фото счетчика по месяцам, свежие первыми
+  function renderTimeline(counterNumber) {
+    timelineEl.innerHTML = '';
+    timelineEl.appendChild(element('h2', null, 'Счетчик ' + counterNumber));
+
+    if (currentPhotos.length === 0) {
+      timelineEl.appendChild(element('p', 'hint', 'Фото нет'));
+      return;
+    }
+
+    var month = null;
+    var grid = null;
+    currentPhotos.forEach(function (photo, index) {
+      var title = monthTitle(photo.date);
+      if (title !== month) {
+        month = title;
+        timelineEl.appendChild(element('h3', null, title));
+        grid = element('div', 'photos');
+        timelineEl.appendChild(grid);
+      }
+      grid.appendChild(photoCard(photo, index));
+    });
+  }
+
+  function photoCard(photo, index) {
+    var card = element('div', 'photo');
+    var img = element('img');
+    img.loading = 'lazy';
+    img.alt = photo.path;
+    showImage(img, photo, 256);
+    card.appendChild(img);
+
+    var info = element('div', 'info');
+    info.appendChild(element('div', 'date', formatDate(photo.date)));
+    info.appendChild(element('div', 'size', formatSize(photo.size)));
+    if (readingText(photo)) {
+      info.appendChild(element('div', 'reading', readingText(photo)));
+    }
+    if (anomalyText(photo)) {
+      info.appendChild(element('div', 'anomaly', anomalyText(photo)));
+    }
+    if (photo.userComment) {
+      info.appendChild(element('div', 'comment', photo.userComment));
+    }
+    card.appendChild(info);
+
+    card.addEventListener('click', function () {
+      openViewer(index);
+    });
+    return card;
+  }
+
+  function openViewer(index) {
+    if (index < 0 || index >= currentPhotos.length) {
+      return;
+    }
+    viewerIndex = index;
+    var photo = currentPhotos[index];
+    var url = photoURL(photo.path);
+    showImage(viewerImage, photo, 1024);
+    viewerCaption.innerHTML = '';
+    viewerCaption.appendChild(document.createTextNode(formatDate(photo.date) + ' · ' + formatSize(photo.size) + ' · '));
+    var link = element('a', null, 'открыть оригинал');
+    link.href = url;
+    link.target = '_blank';
+    viewerCaption.appendChild(link);
+    if (readingText(photo)) {
+      viewerCaption.appendChild(element('div', 'reading', readingText(photo)));
+    }
+    if (anomalyText(photo)) {
+      viewerCaption.appendChild(element('div', 'anomaly', anomalyText(photo)));
+    }
+    if (photo.notes) {
+      viewerCaption.appendChild(element('div', 'comment', photo.notes));
+    } else if (photo.userComment) {
+      viewerCaption.appendChild(element('div', 'comment', photo.userComment));
+    }
+    viewerEl.hidden = false;
+  }
+
+  function closeViewer() {
+    viewerEl.hidden = true;
+    viewerImage.removeAttribute('src');
+    viewerIndex = -1;
+  }
+
+  function showError(err) {
+    if (err && err.message === 'admin key is required') {
+      return;
+    }
+    timelineEl.innerHTML = '';
+    timelineEl.appendChild(element('p', 'hint', 'Ошибка загрузки: ' + (err && err.message)));
+  }
+
+  function showLogin() {
+    document.getElementById('login').hidden = false;
+    document.getElementById('login-key').focus();
+  }
+
+  document.getElementById('login-form').addEventListener('submit', function (event) {
+    event.preventDefault();
+    var keyEl = document.getElementById('login-key');
+    var errorEl = document.getElementById('login-error');
+    fetch('/admin/login', {
+      method: 'POST',
+      credentials: 'same-origin',
+      headers: { 'X-Admin-Key': keyEl.value.trim() }
+    }).then(function (resp) {
+      if (!resp.ok) {
+        errorEl.hidden = false;
+        keyEl.select();
+        return;
+      }
+      keyEl.value = '';
+      errorEl.hidden = true;
+      document.getElementById('login').hidden = true;
+      loadCounters();
+    }, function () {
+      errorEl.hidden = false;
+    });
+  });
+
+  filterEl.addEventListener('input', renderCounters);
+
+  window.addEventListener('hashchange', function () {
+    selectCounter(decodeURIComponent(location.hash.slice(1)));
+  });
+
+  document.getElementById('viewer-close').addEventListener('click', closeViewer);
+  document.getElementById('viewer-prev').addEventListener('click', function () { openViewer(viewerIndex - 1); });
+  document.getElementById('viewer-next').addEventListener('click', function () { openViewer(viewerIndex + 1); });
+  viewerEl.addEventListener('click', function (event) {
+    if (event.target === viewerEl) {
+      closeViewer();
+    }
+  });
+
+  document.addEventListener('keydown', function (event) {
+    if (viewerEl.hidden) {
+      return;
+    }
+    if (event.key === 'Escape') {
+      closeViewer();
+    } else if (event.key === 'ArrowLeft') {
+      openViewer(viewerIndex - 1);
+    } else if (event.key === 'ArrowRight') {
+      openViewer(viewerIndex + 1);
+    }
+  });
+
+  loadCounters();
+})();
@@ -0,0 +1,21 @@
+// Package web содержит встроенный в exe веб-интерфейс сервера
+package web
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed gallery
+var files embed.FS
+
+// Gallery возвращает файлы галереи фото для раздачи через http
+func Gallery() http.FileSystem {
+	sub, err := fs.Sub(files, "gallery")
+	if err != nil {
+		// Папка встроена при сборке, ошибка возможна только при опечатке в пути
+		panic(err)
+	}
+	return http.FS(sub)
+}